@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	trackAccessCount = flag.Bool("track-access-count", false, "Count file reads and store the counts in sidecars")
+	accessTopN       = flag.Int("access-top-n", 20, "Number of files reported as hot files")
+)
+
+const (
+	accessCountSuffix        = ".restfs-access-count"
+	accessCountFlushInterval = 60 * time.Second
+	accessCountFlushWorkers  = 4
+	accessTotalsMax          = 10000
+)
+
+type accessCount struct {
+	Count      int64     `json:"count"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+var accessCounts = struct {
+	sync.Mutex
+	once sync.Once
+	// flushing serializes flushes, which read and write the same sidecars.
+	flushing sync.Mutex
+	pending  map[string]*accessCount
+	totals   map[string]*accessCount
+}{
+	pending: make(map[string]*accessCount),
+	totals:  make(map[string]*accessCount),
+}
+
+func init() {
+	sidecarSuffixes = append(sidecarSuffixes, accessCountSuffix)
+	registerEndpoint("/-/admin/stats/hot-files", serveHotFiles)
+	registerEndpoint("/-/admin/stats/flush-access-counts", serveFlushAccessCounts)
+}
+
+func recordAccess(fullpath string) {
+	if !*trackAccessCount {
+		return
+	}
+	accessCounts.once.Do(func() {
+		go func() {
+			for range time.Tick(accessCountFlushInterval) {
+				flushAccessCounts()
+			}
+		}()
+	})
+
+	accessCounts.Lock()
+	defer accessCounts.Unlock()
+	ac := accessCounts.pending[fullpath]
+	if ac == nil {
+		ac = &accessCount{}
+		accessCounts.pending[fullpath] = ac
+	}
+	ac.Count++
+	ac.LastAccess = time.Now()
+}
+
+// flushAccessCounts adds the counts collected since the last flush to the
+// sidecars of each file.
+func flushAccessCounts() {
+	accessCounts.flushing.Lock()
+	defer accessCounts.flushing.Unlock()
+
+	accessCounts.Lock()
+	pending := accessCounts.pending
+	accessCounts.pending = make(map[string]*accessCount)
+	accessCounts.Unlock()
+
+	names := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < accessCountFlushWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				total, err := flushAccessCount(name, pending[name])
+				if err != nil {
+					log.Printf("Failed to save access count of %s: %v", name, err)
+					continue
+				}
+				accessCounts.Lock()
+				accessCounts.totals[name] = total
+				accessCounts.Unlock()
+				// A new sidecar changes the mtime of the directory.
+				requestIndexRebuild(path.Dir(name))
+			}
+		}()
+	}
+	for name := range pending {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	accessCounts.Lock()
+	pruneAccessTotals(accessTotalsMax)
+	accessCounts.Unlock()
+}
+
+// pruneAccessTotals keeps the max most accessed files in memory. The counts
+// of the others stay in their sidecars and are picked up again on their next
+// access.
+func pruneAccessTotals(max int) {
+	if len(accessCounts.totals) <= max {
+		return
+	}
+	names := make([]string, 0, len(accessCounts.totals))
+	for name := range accessCounts.totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return accessCounts.totals[names[i]].Count > accessCounts.totals[names[j]].Count
+	})
+	for _, name := range names[max:] {
+		delete(accessCounts.totals, name)
+	}
+}
+
+func flushAccessCount(fullpath string, delta *accessCount) (*accessCount, error) {
+	// Counts belong to the path rather than to a version of the file, so
+	// unlike other sidecars the count is not stale after an overwrite.
+	var total accessCount
+	b, err := ioutil.ReadFile(fullpath + accessCountSuffix)
+	if err == nil {
+		err = json.Unmarshal(b, &total)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	total.Count += delta.Count
+	total.LastAccess = delta.LastAccess
+	if err := writeSidecar(fullpath, accessCountSuffix, &total); err != nil {
+		return nil, err
+	}
+	return &total, nil
+}
+
+type hotFile struct {
+	Path string `json:"path"`
+	accessCount
+}
+
+func serveHotFiles(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	accessCounts.Lock()
+	counts := make(map[string]accessCount)
+	for name, ac := range accessCounts.totals {
+		counts[name] = *ac
+	}
+	for name, ac := range accessCounts.pending {
+		total := counts[name]
+		total.Count += ac.Count
+		total.LastAccess = ac.LastAccess
+		counts[name] = total
+	}
+	accessCounts.Unlock()
+
+	files := []hotFile{}
+	for name, ac := range counts {
+		p, err := c.urlpath(name)
+		if err != nil {
+			continue
+		}
+		files = append(files, hotFile{Path: p, accessCount: ac})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Count > files[j].Count })
+	if len(files) > *accessTopN {
+		files = files[:*accessTopN]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"files": files})
+}
+
+func serveFlushAccessCounts(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	flushAccessCounts()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// resetAccessCounts clears the in-memory counts for the duration of the test.
+func resetAccessCounts(t *testing.T) {
+	setFlag(t, "track-access-count", "true")
+	reset := func() {
+		accessCounts.Lock()
+		accessCounts.pending = make(map[string]*accessCount)
+		accessCounts.totals = make(map[string]*accessCount)
+		accessCounts.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func readAccessCount(t *testing.T, fullpath string) int64 {
+	t.Helper()
+	b, err := ioutil.ReadFile(fullpath + accessCountSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ac accessCount
+	if err := json.Unmarshal(b, &ac); err != nil {
+		t.Fatal(err)
+	}
+	return ac.Count
+}
+
+func TestAccessCountFlush(t *testing.T) {
+	c := newTestFS(t)
+	resetAccessCounts(t)
+	mustPut(t, c, "/a.txt", "a")
+	for i := 0; i < 3; i++ {
+		do(c, "GET", "/a.txt", "")
+	}
+	do(c, "GET", "/missing", "")
+	if rec := do(c.adminHandler(), "POST", "/-/admin/stats/flush-access-counts", ""); rec.Code != http.StatusOK {
+		t.Fatalf("flush = %d", rec.Code)
+	}
+	fullpath := filepath.Join(c.dir, "a.txt")
+	if n := readAccessCount(t, fullpath); n != 3 {
+		t.Fatalf("count = %d, want 3", n)
+	}
+
+	// An overwrite keeps counting where it left off.
+	mustPut(t, c, "/a.txt", "aa")
+	do(c, "GET", "/a.txt", "")
+	flushAccessCounts()
+	if n := readAccessCount(t, fullpath); n != 4 {
+		t.Fatalf("count after overwrite = %d, want 4", n)
+	}
+}
+
+func TestAccessCountConcurrentFlush(t *testing.T) {
+	c := newTestFS(t)
+	resetAccessCounts(t)
+	mustPut(t, c, "/a.txt", "a")
+	fullpath := filepath.Join(c.dir, "a.txt")
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recordAccess(fullpath)
+			flushAccessCounts()
+		}()
+	}
+	wg.Wait()
+	flushAccessCounts()
+	if n := readAccessCount(t, fullpath); n != 50 {
+		t.Fatalf("count = %d, want 50", n)
+	}
+}
+
+func TestHotFiles(t *testing.T) {
+	c := newTestFS(t)
+	resetAccessCounts(t)
+	setFlag(t, "access-top-n", "2")
+	for i, name := range []string{"a", "b", "c"} {
+		mustPut(t, c, "/"+name, name)
+		for j := 0; j <= i; j++ {
+			do(c, "GET", "/"+name, "")
+		}
+	}
+	flushAccessCounts()
+	for i := 0; i < 3; i++ {
+		do(c, "GET", "/a", "")
+	}
+
+	rec := do(c.adminHandler(), "GET", "/-/admin/stats/hot-files", "")
+	var res struct {
+		Files []hotFile `json:"files"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 2 {
+		t.Fatalf("%d hot files, want 2", len(res.Files))
+	}
+	got := fmt.Sprintf("%s %d %s %d", res.Files[0].Path, res.Files[0].Count, res.Files[1].Path, res.Files[1].Count)
+	if want := "/a 4 /c 3"; got != want {
+		t.Fatalf("hot files = %s, want %s", got, want)
+	}
+}
+
+func TestPruneAccessTotals(t *testing.T) {
+	resetAccessCounts(t)
+	accessCounts.Lock()
+	defer accessCounts.Unlock()
+	for i := 0; i < 10; i++ {
+		accessCounts.totals[fmt.Sprint(i)] = &accessCount{Count: int64(i)}
+	}
+	pruneAccessTotals(3)
+	if len(accessCounts.totals) != 3 {
+		t.Fatalf("%d totals left, want 3", len(accessCounts.totals))
+	}
+	for _, name := range []string{"7", "8", "9"} {
+		if accessCounts.totals[name] == nil {
+			t.Errorf("%s was pruned", name)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yosisa/webutil"
+)
+
+var (
+	adminListen = flag.String("admin-listen", "", "Listen address for the admin endpoints under /-/admin/; they are not served without it")
+	adminAllow  = flag.String("admin-allow", "", "Addresses or CIDRs (comma-separated) allowed to use admin and debug endpoints; empty allows all")
+)
+
+const (
+	adminPrefix    = "/-/admin/"
+	adminGCTimeout = 5 * time.Second
+)
+
+func init() {
+	registerValidator(func() error {
+		_, err := parseNets("admin-allow", *adminAllow)
+		return err
+	})
+	registerValidator(func() error {
+		if *adminListen == "" {
+			return nil
+		}
+		if _, _, err := net.SplitHostPort(*adminListen); err != nil {
+			return fmt.Errorf("invalid -admin-listen address %q: %v", *adminListen, err)
+		}
+		if addrConflicts(*adminListen, *listen) {
+			return fmt.Errorf("-admin-listen address %s conflicts with -listen address %s", *adminListen, *listen)
+		}
+		for _, addr := range prometheusAddrs {
+			if addrConflicts(*adminListen, addr) {
+				return fmt.Errorf("-admin-listen address %s conflicts with -prometheus address %s", *adminListen, addr)
+			}
+		}
+		return nil
+	})
+	registerEndpoint("/-/admin/gc/file", serveFileGC)
+}
+
+// adminHandler serves the admin endpoints, which are only reachable on the
+// admin listener. The middlewares of the public listener do not apply.
+func (c *restfs) adminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, ok := endpoints[r.URL.Path]
+		if !ok || !strings.HasPrefix(r.URL.Path, adminPrefix) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if !adminAllowed(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		e(c, w, r)
+	})
+}
+
+func serveAdmin(c *restfs) {
+	log.Printf("Admin endpoints at %s", *adminListen)
+	srv := &http.Server{
+		Addr:     *adminListen,
+		Handler:  recoverMiddleware(webutil.Logger(c.adminHandler(), accessLogWriter)),
+		ErrorLog: serverErrorLog,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// adminAllowed reports whether the client of r may use admin endpoints.
+func adminAllowed(r *http.Request) bool {
+	if *adminAllow == "" {
+		return true
+	}
+	nets, _ := parseNets("admin-allow", *adminAllow)
+	return netsContain(nets, r.RemoteAddr)
+}
+
+// parseNets parses the comma-separated addresses and CIDRs of the named flag.
+func parseNets(name, s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	if s == "" {
+		return nets, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid -%s entry %q", name, item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s entry %q: %v", name, item, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func netsContain(nets []*net.IPNet, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+type fileGCResult struct {
+	Removed    bool   `json:"removed"`
+	BytesFreed int64  `json:"bytes_freed,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+func serveFileGC(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+	fullpath := c.fullpath(p)
+
+	tpath, tstat, err := findTombstone(fullpath)
+	if os.IsNotExist(err) {
+		writeJSON(w, http.StatusOK, &fileGCResult{Reason: "no tombstone"})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var result fileGCResult
+	fstat, err := entryStat(fullpath)
+	if src := writeBuf.locate(fullpath); src != fullpath {
+		// Judge by the buffered upload, as collect does.
+		fstat, err = os.Stat(src)
+	}
+	switch {
+	case err == nil && fstat.ModTime().After(tstat.ModTime()):
+		result.Reason = "file is newer than tombstone"
+	case err == nil:
+		result.Removed = true
+		result.BytesFreed = fstat.Size()
+	case os.IsNotExist(err):
+		result.Reason = "data file missing"
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- collect(tpath, tstat) }()
+	select {
+	case err = <-done:
+	case <-time.After(adminGCTimeout):
+		http.Error(w, "GC timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &result)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var adminEndpoints = []string{
+	"/-/admin/gc/file",
+	"/-/admin/introspect",
+	"/-/admin/presign-bundle",
+	"/-/admin/stats/hot-files",
+	"/-/admin/stats/flush-access-counts",
+}
+
+func TestAdminEndpointsNotPublic(t *testing.T) {
+	c := newTestFS(t)
+	for _, p := range adminEndpoints {
+		for _, method := range []string{"GET", "POST"} {
+			if rec := do(c, method, p, ""); rec.Code != http.StatusNotFound {
+				t.Errorf("%s %s on public handler: %d, want 404", method, p, rec.Code)
+			}
+		}
+	}
+	// A file under the prefix is not reachable either.
+	if rec := do(c, "PUT", "/-/admin/x", "data"); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT under admin prefix: %d, want 404", rec.Code)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	c := newTestFS(t)
+	h := c.adminHandler()
+	for _, p := range adminEndpoints {
+		if rec := do(h, "GET", p, ""); rec.Code == http.StatusNotFound && p != "/-/admin/presign-bundle" {
+			t.Errorf("GET %s on admin handler: 404", p)
+		}
+	}
+	if rec := do(h, "GET", "/-/admin/introspect?path=/", ""); rec.Code != http.StatusOK {
+		t.Errorf("introspect: %d %s", rec.Code, rec.Body)
+	}
+	mustPut(t, c, "/a.txt", "data")
+	for _, p := range []string{"/a.txt", "/-/admin/unknown", "/-/batch-get"} {
+		if rec := do(h, "GET", p, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s on admin handler: %d, want 404", p, rec.Code)
+		}
+	}
+}
+
+func TestAdminHandlerAllowlist(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "admin-allow", "10.0.0.0/8")
+	h := c.adminHandler()
+
+	r := newRequest("GET", "/-/admin/introspect?path=/", "")
+	r.RemoteAddr = "192.0.2.1:1234"
+	if rec := serve(h, r); rec.Code != http.StatusForbidden {
+		t.Errorf("disallowed client: %d, want 403", rec.Code)
+	}
+	r = newRequest("GET", "/-/admin/introspect?path=/", "")
+	r.RemoteAddr = "10.1.2.3:1234"
+	if rec := serve(h, r); rec.Code != http.StatusOK {
+		t.Errorf("allowed client: %d, want 200", rec.Code)
+	}
+}
+
+func fileGC(t *testing.T, c *restfs, p string) fileGCResult {
+	t.Helper()
+	rec := do(c.adminHandler(), "POST", "/-/admin/gc/file?path="+url.QueryEscape(p), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("gc %s: %d %s", p, rec.Code, rec.Body)
+	}
+	var res fileGCResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestFileGC(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "12345")
+	if rec := do(c, "DELETE", "/a.txt", ""); rec.Code >= 300 {
+		t.Fatalf("DELETE: %d", rec.Code)
+	}
+	if res := fileGC(t, c, "/a.txt"); !res.Removed || res.BytesFreed != 5 {
+		t.Fatalf("result = %+v", res)
+	}
+	for _, name := range []string{"a.txt", "a.txt" + tombstone, "a.txt" + checksumSuffix} {
+		if _, err := os.Stat(filepath.Join(c.dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", name, err)
+		}
+	}
+	if res := fileGC(t, c, "/a.txt"); res.Removed || res.Reason != "no tombstone" {
+		t.Fatalf("second GC = %+v", res)
+	}
+}
+
+func TestFileGCNotRemoved(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/kept.txt", "data")
+	if res := fileGC(t, c, "/kept.txt"); res.Removed || res.Reason != "no tombstone" {
+		t.Errorf("live file: %+v", res)
+	}
+	if res := fileGC(t, c, "/../../etc/passwd"); res.Removed || res.Reason != "no tombstone" {
+		t.Errorf("path outside the data directory: %+v", res)
+	}
+
+	// The file was written again after the tombstone.
+	mustPut(t, c, "/newer.txt", "data")
+	do(c, "DELETE", "/newer.txt", "")
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(c.dir, "newer.txt"), later, later)
+	if res := fileGC(t, c, "/newer.txt"); res.Removed || res.Reason != "file is newer than tombstone" {
+		t.Errorf("newer file: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "newer.txt")); err != nil {
+		t.Errorf("newer file removed: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(c.dir, "orphan.txt"+tombstone), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if res := fileGC(t, c, "/orphan.txt"); res.Removed || res.Reason != "data file missing" {
+		t.Errorf("orphan tombstone: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "orphan.txt"+tombstone)); !os.IsNotExist(err) {
+		t.Errorf("orphan tombstone not removed: %v", err)
+	}
+}
+
+func TestFileGCBufferedUpload(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/a.txt", "old")
+	writeBuf.flush()
+	time.Sleep(10 * time.Millisecond)
+	do(c, "DELETE", "/a.txt", "")
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, c, "/a.txt", "newer")
+
+	if res := fileGC(t, c, "/a.txt"); res.Removed || res.BytesFreed != 0 || res.Reason != "file is newer than tombstone" {
+		t.Fatalf("result = %+v", res)
+	}
+	writeBuf.flush()
+	if rec := do(c, "GET", "/a.txt", ""); rec.Body.String() != "newer" {
+		t.Fatalf("GET after flush = %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestFileGCBadRequest(t *testing.T) {
+	c := newTestFS(t)
+	h := c.adminHandler()
+	if rec := do(h, "GET", "/-/admin/gc/file?path=/a.txt", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %d", rec.Code)
+	}
+	if rec := do(h, "POST", "/-/admin/gc/file", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing path: %d", rec.Code)
+	}
+}
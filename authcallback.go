@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var authCallbackURL = flag.String("auth-callback-url", "", "URL of an HTTP callback that authorizes each request")
+
+const (
+	authCallbackTimeout = 2 * time.Second
+	authCacheTTL        = 30 * time.Second
+	authCacheSize       = 1024
+)
+
+type authRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	User    string            `json:"user,omitempty"`
+	Roles   []string          `json:"roles,omitempty"`
+}
+
+type authDecision struct {
+	Allowed bool     `json:"allowed"`
+	User    string   `json:"user,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+type authContextKey struct{}
+
+// authIdentity returns the identity attached to the request by the
+// authentication middleware, if any.
+func authIdentity(r *http.Request) *authDecision {
+	d, _ := r.Context().Value(authContextKey{}).(*authDecision)
+	return d
+}
+
+func init() {
+	registerInspector(20, "auth_callback", inspectAuthCallback)
+	registerMiddleware(20, func(h http.Handler) http.Handler {
+		if *authCallbackURL == "" {
+			return h
+		}
+
+		log.Printf("Authorization callback: %s", *authCallbackURL)
+		return withAuthCallback(h, *authCallbackURL)
+	})
+}
+
+// callbackAuth is set when the authorization callback is enabled. Endpoints
+// acting on paths other than the request path use it to authorize each one.
+var callbackAuth *authorizer
+
+type authorizer struct {
+	url    string
+	client *http.Client
+	cache  *authCache
+}
+
+func newAuthorizer(url string) *authorizer {
+	return &authorizer{
+		url:    url,
+		client: &http.Client{Timeout: authCallbackTimeout},
+		cache:  newAuthCache(authCacheSize, authCacheTTL),
+	}
+}
+
+// authorize asks the callback whether the credentials of r allow method on p.
+// An identity from a session cookie is passed along for the callback to
+// decide on.
+func (a *authorizer) authorize(r *http.Request, method, p string) (*authDecision, error) {
+	req := &authRequest{
+		Method:  method,
+		Path:    p,
+		Headers: make(map[string]string),
+	}
+	for _, name := range []string{"Authorization", "X-Api-Key"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Headers[name] = v
+		}
+	}
+	if id := authIdentity(r); id != nil {
+		req.User = id.User
+		req.Roles = id.Roles
+	}
+
+	var key string
+	if len(req.Headers) > 0 || req.User != "" {
+		key = fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s\x00%s", req.Headers["Authorization"], req.Headers["X-Api-Key"], req.User, strings.Join(req.Roles, ","), method, p)
+	}
+	if d := a.cache.get(key); d != nil {
+		return d, nil
+	}
+	d, err := callAuth(r.Context(), a.client, a.url, req)
+	if err != nil {
+		return nil, err
+	}
+	if d.Allowed && key != "" {
+		a.cache.add(key, d)
+	}
+	return d, nil
+}
+
+// authorizePath returns a non-zero status code and a message if the client
+// of r may not perform method on p.
+func authorizePath(r *http.Request, method, p string) (int, string) {
+	if callbackAuth == nil {
+		return 0, ""
+	}
+	d, err := callbackAuth.authorize(r, method, p)
+	if err != nil {
+		log.Printf("Authorization callback failed: %v", err)
+		return http.StatusServiceUnavailable, "Authorization service unavailable"
+	}
+	if !d.Allowed {
+		msg := http.StatusText(http.StatusForbidden)
+		if d.Reason != "" {
+			msg += ": " + d.Reason
+		}
+		return http.StatusForbidden, msg
+	}
+	return 0, ""
+}
+
+func withAuthCallback(h http.Handler, url string) http.Handler {
+	a := newAuthorizer(url)
+	callbackAuth = a
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Already authorized by a signed URL.
+		if bundleRequestValid(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		d, err := a.authorize(r, r.Method, r.URL.Path)
+		if err != nil {
+			log.Printf("Authorization callback failed: %v", err)
+			http.Error(w, "Authorization service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !d.Allowed {
+			msg := http.StatusText(http.StatusForbidden)
+			if d.Reason != "" {
+				msg += ": " + d.Reason
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+		if id := authIdentity(r); id != nil && d.User == "" {
+			d = &authDecision{Allowed: true, User: id.User, Roles: id.Roles, Reason: d.Reason}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, d)))
+	})
+}
+
+// inspectAuthCallback asks the callback whether the credentials of r would
+// be allowed.
+func inspectAuthCallback(r *http.Request, res *introspection) bool {
+	if *authCallbackURL == "" {
+		return false
+	}
+	res.WouldAuthenticate = true
+	res.AuthMethod = "callback"
+	req := &authRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Headers: make(map[string]string),
+	}
+	for _, name := range []string{"Authorization", "X-Api-Key"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Headers[name] = v
+		}
+	}
+	d, err := callAuth(r.Context(), &http.Client{Timeout: authCallbackTimeout}, *authCallbackURL, req)
+	if err != nil {
+		res.WouldAuthorize = false
+		res.ACLRule = "callback failed: " + err.Error()
+		return true
+	}
+	res.WouldAuthorize = d.Allowed
+	res.ACLRule = d.Reason
+	return true
+}
+
+func callAuth(ctx context.Context, client *http.Client, url string, req *authRequest) (*authDecision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(resp.Status)
+	}
+	var d authDecision
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+type authCacheEntry struct {
+	key     string
+	d       *authDecision
+	expires time.Time
+}
+
+// authCache is a small LRU cache of allowed decisions.
+type authCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newAuthCache(size int, ttl time.Duration) *authCache {
+	return &authCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *authCache) get(key string) *authDecision {
+	if key == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*authCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return nil
+	}
+	c.ll.MoveToFront(e)
+	return entry.d
+}
+
+func (c *authCache) add(key string, d *authDecision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &authCacheEntry{key: key, d: d, expires: time.Now().Add(c.ttl)}
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	if c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*authCacheEntry).key)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuthCallback(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req authRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		d := authDecision{Reason: "read only"}
+		if req.Headers["Authorization"] == "Bearer good" && req.Method == "GET" {
+			d = authDecision{Allowed: true, User: "alice", Roles: []string{"reader"}}
+		}
+		json.NewEncoder(w).Encode(&d)
+	}))
+	defer srv.Close()
+	t.Cleanup(func() { callbackAuth = nil })
+	h := withAuthCallback(identityHandler, srv.URL)
+
+	get := func(method, token string) *httptest.ResponseRecorder {
+		r := newRequest(method, "/a.txt", "")
+		if token != "" {
+			r.Header.Set("Authorization", token)
+		}
+		return serve(h, r)
+	}
+
+	rec := get("GET", "Bearer good")
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Fatalf("allowed: %d %q", rec.Code, rec.Body)
+	}
+	rec = get("PUT", "Bearer good")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "read only") {
+		t.Fatalf("denied: %d %q", rec.Code, rec.Body)
+	}
+
+	// Allowed decisions are cached per token, denied ones are not.
+	atomic.StoreInt32(&calls, 0)
+	get("GET", "Bearer good")
+	get("PUT", "Bearer good")
+	get("PUT", "Bearer good")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("callback called %d times, want 2", n)
+	}
+	// Anonymous requests are never cached.
+	atomic.StoreInt32(&calls, 0)
+	get("GET", "")
+	get("GET", "")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("anonymous: callback called %d times, want 2", n)
+	}
+}
+
+func TestAuthCallbackForwardsHeaders(t *testing.T) {
+	var got authRequest
+	h := withTestAuth(t, identityHandler, func(req *authRequest) bool {
+		got = *req
+		return true
+	})
+	r := newRequest("DELETE", "/dir/a.txt", "")
+	r.Header.Set("Authorization", "Bearer x")
+	r.Header.Set("X-Api-Key", "key")
+	r.Header.Set("Cookie", "other=1")
+	serve(h, r)
+	if got.Method != "DELETE" || got.Path != "/dir/a.txt" {
+		t.Fatalf("request = %+v", got)
+	}
+	if len(got.Headers) != 2 || got.Headers["Authorization"] != "Bearer x" || got.Headers["X-Api-Key"] != "key" {
+		t.Fatalf("headers = %v", got.Headers)
+	}
+}
+
+func TestAuthCallbackUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	t.Cleanup(func() { callbackAuth = nil })
+	captureLog(t)
+
+	if rec := do(withAuthCallback(identityHandler, srv.URL), "GET", "/a.txt", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("failing callback: %d", rec.Code)
+	}
+	srv.Close()
+	if rec := do(withAuthCallback(identityHandler, srv.URL), "GET", "/a.txt", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unreachable callback: %d", rec.Code)
+	}
+}
+
+func TestAuthCallbackTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the callback timeout")
+	}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	t.Cleanup(func() { callbackAuth = nil })
+	captureLog(t)
+
+	start := time.Now()
+	if rec := do(withAuthCallback(identityHandler, srv.URL), "GET", "/a.txt", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("slow callback: %d", rec.Code)
+	}
+	if d := time.Since(start); d > authCallbackTimeout+time.Second {
+		t.Fatalf("request took %s", d)
+	}
+}
+
+func TestAuthCache(t *testing.T) {
+	c := newAuthCache(2, time.Minute)
+	a, b, d := &authDecision{User: "a"}, &authDecision{User: "b"}, &authDecision{User: "d"}
+	c.add("a", a)
+	c.add("b", b)
+	c.get("a")
+	c.add("d", d)
+	if c.get("b") != nil {
+		t.Fatal("least recently used entry was kept")
+	}
+	if c.get("a") != a || c.get("d") != d {
+		t.Fatal("recent entries were evicted")
+	}
+	if c.get("") != nil {
+		t.Fatal("empty key cached")
+	}
+
+	c = newAuthCache(2, -time.Second)
+	c.add("a", a)
+	if c.get("a") != nil {
+		t.Fatal("expired entry returned")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bandwidthInterval = 10 * time.Second
+	bandwidthAlpha    = 0.3
+	// Directories whose rate decayed below this without new transfers are
+	// no longer reported.
+	bandwidthMinRate = 1
+)
+
+var bandwidth = newBandwidthTracker()
+
+// bandwidthTracker aggregates bytes transferred per top-level directory and
+// smooths the rates with an exponential moving average.
+type bandwidthTracker struct {
+	mu      sync.Mutex
+	enabled bool
+	written map[string]int64
+	read    map[string]int64
+	wrate   map[string]float64
+	rrate   map[string]float64
+}
+
+func newBandwidthTracker() *bandwidthTracker {
+	return &bandwidthTracker{
+		written: make(map[string]int64),
+		read:    make(map[string]int64),
+		wrate:   make(map[string]float64),
+		rrate:   make(map[string]float64),
+	}
+}
+
+func (b *bandwidthTracker) start() {
+	b.mu.Lock()
+	b.enabled = true
+	b.mu.Unlock()
+	go func() {
+		for range time.Tick(bandwidthInterval) {
+			b.update(bandwidthInterval)
+		}
+	}()
+}
+
+// reportWrite records n bytes successfully written to dir, as returned by
+// bandwidthDir.
+func (b *bandwidthTracker) reportWrite(dir string, n int64) {
+	b.report(b.written, dir, n)
+}
+
+// reportRead records n bytes successfully read from dir, as returned by
+// bandwidthDir.
+func (b *bandwidthTracker) reportRead(dir string, n int64) {
+	b.report(b.read, dir, n)
+}
+
+func (b *bandwidthTracker) report(m map[string]int64, dir string, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.enabled {
+		m[dir] += n
+	}
+}
+
+func (b *bandwidthTracker) update(interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, dir := range updateEMA(b.wrate, b.written, interval) {
+		dirWriteRate.DeleteLabelValues(dir)
+	}
+	for _, dir := range updateEMA(b.rrate, b.read, interval) {
+		dirReadRate.DeleteLabelValues(dir)
+	}
+	for dir, rate := range b.wrate {
+		dirWriteRate.WithLabelValues(dir).Set(rate)
+	}
+	for dir, rate := range b.rrate {
+		dirReadRate.WithLabelValues(dir).Set(rate)
+	}
+}
+
+// updateEMA folds counts into rates and returns the directories that became
+// idle and were removed.
+func updateEMA(rates map[string]float64, counts map[string]int64, interval time.Duration) (idle []string) {
+	for dir := range counts {
+		if _, ok := rates[dir]; !ok {
+			rates[dir] = 0
+		}
+	}
+	for dir, prev := range rates {
+		n, ok := counts[dir]
+		rate := bandwidthAlpha*float64(n)/interval.Seconds() + (1-bandwidthAlpha)*prev
+		if !ok && rate < bandwidthMinRate {
+			delete(rates, dir)
+			idle = append(idle, dir)
+			continue
+		}
+		rates[dir] = rate
+		delete(counts, dir)
+	}
+	return idle
+}
+
+// bandwidthDir returns the label for transfers to urlpath: its top-level
+// directory, or "/" when that is not an existing directory. Labels are
+// limited to existing directories so that clients cannot create series at
+// will.
+func (c *restfs) bandwidthDir(urlpath string) string {
+	dir := topDir(path.Clean("/" + urlpath))
+	if dir != "/" && len(c.dirpaths(dir)) == 0 {
+		return "/"
+	}
+	return dir
+}
+
+func topDir(urlpath string) string {
+	p := strings.TrimPrefix(urlpath, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return "/" + p[:i]
+	}
+	return "/"
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n    int64
+	code int
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// ok reports whether the response was successful.
+func (w *countingWriter) ok() bool {
+	return w.code < http.StatusMultipleChoices
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
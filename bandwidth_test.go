@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// enableBandwidth replaces the global tracker with an enabled one that is
+// not updated in the background.
+func enableBandwidth(t *testing.T) *bandwidthTracker {
+	old := bandwidth
+	bandwidth = newBandwidthTracker()
+	bandwidth.enabled = true
+	t.Cleanup(func() { bandwidth = old })
+	return bandwidth
+}
+
+func TestUpdateEMAConverges(t *testing.T) {
+	rates := make(map[string]float64)
+	counts := make(map[string]int64)
+	for i := 0; i < 50; i++ {
+		counts["/a"] = 1000
+		updateEMA(rates, counts, time.Second)
+	}
+	if math.Abs(rates["/a"]-1000) > 0.01 {
+		t.Fatalf("rate = %v, want 1000", rates["/a"])
+	}
+	if len(counts) != 0 {
+		t.Fatalf("counts were not consumed: %v", counts)
+	}
+
+	// The first interval is weighted by alpha.
+	rates = make(map[string]float64)
+	counts["/b"] = 100
+	updateEMA(rates, counts, 10*time.Second)
+	if want := bandwidthAlpha * 10; math.Abs(rates["/b"]-want) > 1e-9 {
+		t.Fatalf("rate = %v, want %v", rates["/b"], want)
+	}
+}
+
+func TestUpdateEMAExpiresIdle(t *testing.T) {
+	rates := map[string]float64{"/a": 1000}
+	var idle []string
+	for i := 0; i < 100 && len(idle) == 0; i++ {
+		idle = updateEMA(rates, map[string]int64{}, time.Second)
+	}
+	if len(idle) != 1 || idle[0] != "/a" {
+		t.Fatalf("idle = %v", idle)
+	}
+	if _, ok := rates["/a"]; ok {
+		t.Fatal("idle directory was kept")
+	}
+}
+
+func TestBandwidthReportsSuccessfulTransfers(t *testing.T) {
+	c := newTestFS(t)
+	b := enableBandwidth(t)
+	mustPut(t, c, "/dir/a.txt", "12345")
+	mustPut(t, c, "/root.txt", "123")
+	do(c, "GET", "/dir/a.txt", "")
+
+	// Failed requests and paths outside existing directories.
+	do(c, "PUT", "/root.txt/b", "1234567")
+	do(c, "GET", "/nodir/missing", "")
+	do(c, "GET", "/dir/missing", "")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.written) != 2 || b.written["/dir"] != 5 || b.written["/"] != 3 {
+		t.Errorf("written = %v", b.written)
+	}
+	if len(b.read) != 1 || b.read["/dir"] != 5 {
+		t.Errorf("read = %v", b.read)
+	}
+}
+
+func TestBandwidthDir(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/dir/a.txt", "a")
+	for p, want := range map[string]string{
+		"/dir/a.txt":   "/dir",
+		"/dir/x/y":     "/dir",
+		"/a.txt":       "/",
+		"/unknown/a":   "/",
+		"/dir/../x/yz": "/",
+	} {
+		if got := c.bandwidthDir(p); got != want {
+			t.Errorf("bandwidthDir(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+)
+
+var batchGetMaxFiles = flag.Int("batch-get-max-files", 100, "Maximum number of files in a single batch GET")
+
+func init() {
+	registerEndpoint("/-/batch-get", serveBatchGet)
+}
+
+func serveBatchGet(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) > *batchGetMaxFiles {
+		http.Error(w, fmt.Sprintf("Too many paths; at most %d allowed", *batchGetMaxFiles), http.StatusBadRequest)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	for _, p := range req.Paths {
+		if err := writeBatchPart(c, mw, r, p); err != nil {
+			log.Printf("Batch GET aborted at %s: %v", p, err)
+			return
+		}
+	}
+	mw.Close()
+}
+
+// writeBatchPart writes the file at p as a part. Each path is authorized and
+// validated as if it was requested with its own GET; a rejected path gets an
+// empty part with the status code that GET would have returned.
+func writeBatchPart(c *restfs, mw *multipart.Writer, r *http.Request, p string) error {
+	fullpath := c.fullpath(p)
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(fullpath)}))
+
+	code, _ := authorizePath(r, "GET", path.Clean("/"+p))
+	if code == 0 {
+		if _, err := safeStatFollow(fullpath, *symlinkMaxDepth); err == errSymlinkDepth {
+			code = http.StatusBadRequest
+		}
+	}
+	if code != 0 {
+		hdr.Set("X-Restfs-Status", strconv.Itoa(code))
+		_, err := mw.CreatePart(hdr)
+		return err
+	}
+
+	var f *os.File
+	fullpath = c.readablePath(p)
+	s := stat(fullpath)
+	if s != nil && !s.IsDir() && !isReserved(path.Base(fullpath)) {
+		var err error
+		if f, err = os.Open(writeBuf.locate(fullpath)); err != nil {
+			log.Print(err)
+		}
+	}
+	if f == nil {
+		hdr.Set("X-Restfs-Status", strconv.Itoa(http.StatusNotFound))
+		_, err := mw.CreatePart(hdr)
+		return err
+	}
+	defer f.Close()
+
+	hdr.Set("X-Restfs-Status", strconv.Itoa(http.StatusOK))
+	hdr.Set("Content-Type", contentType(fullpath))
+	hdr.Set("Content-Length", strconv.FormatInt(s.Size(), 10))
+	pw, err := mw.CreatePart(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(pw, f, s.Size())
+	return err
+}
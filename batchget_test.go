@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// readBatch returns the X-Restfs-Status and body of each part.
+func readBatch(t *testing.T, resp *http.Response) (codes []string, bodies []string) {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, _ := ioutil.ReadAll(p)
+		codes = append(codes, p.Header.Get("X-Restfs-Status"))
+		bodies = append(bodies, string(b))
+	}
+	return
+}
+
+func TestBatchGet(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "aaa")
+	mustPut(t, c, "/dir/b.txt", "bb")
+
+	rec := do(c, "POST", "/-/batch-get", `{"paths":["/a.txt","/missing","/dir/b.txt"]}`)
+	codes, bodies := readBatch(t, rec.Result())
+	if strings.Join(codes, ",") != "200,404,200" {
+		t.Fatalf("codes = %v", codes)
+	}
+	if bodies[0] != "aaa" || bodies[2] != "bb" {
+		t.Fatalf("bodies = %q", bodies)
+	}
+}
+
+func TestBatchGetAuthorizesEachPath(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/public/a.txt", "public")
+	mustPut(t, c, "/secret/b.txt", "secret")
+
+	var checked []string
+	h := withTestAuth(t, c, func(req *authRequest) bool {
+		checked = append(checked, req.Method+" "+req.Path)
+		return !strings.HasPrefix(req.Path, "/secret/")
+	})
+	rec := do(h, "POST", "/-/batch-get", `{"paths":["public/a.txt","/secret/b.txt","/public/../secret/b.txt"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	codes, bodies := readBatch(t, rec.Result())
+	if strings.Join(codes, ",") != "200,403,403" {
+		t.Fatalf("codes = %v", codes)
+	}
+	for i, b := range bodies[1:] {
+		if b != "" {
+			t.Errorf("denied part %d leaked %q", i+1, b)
+		}
+	}
+	want := "POST /-/batch-get,GET /public/a.txt,GET /secret/b.txt,GET /secret/b.txt"
+	if got := strings.Join(checked, ","); got != want {
+		t.Fatalf("checked %s, want %s", got, want)
+	}
+}
+
+func TestBatchGetTooManyPaths(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "batch-get-max-files", "1")
+	if rec := do(c, "POST", "/-/batch-get", `{"paths":["/a","/b"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
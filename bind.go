@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+)
+
+var bindInterface = flag.String("bind-interface", "", "Listen on the address of this network interface instead of the host in -listen")
+
+func init() {
+	registerValidator(func() error {
+		if *bindInterface != "" {
+			_, err := listenAddr()
+			return err
+		}
+		host, _, err := net.SplitHostPort(*listen)
+		if err != nil {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !isLocalIP(ip) {
+			// Containers and some network setups assign addresses late, so
+			// this is not fatal.
+			log.Printf("Warning: listen address %s is not assigned to any interface", host)
+		}
+		return nil
+	})
+}
+
+// listenAddr returns -listen with the address of -bind-interface as host.
+func listenAddr() (string, error) {
+	if *bindInterface == "" {
+		return *listen, nil
+	}
+	host, port, err := net.SplitHostPort(*listen)
+	if err != nil {
+		return "", fmt.Errorf("-bind-interface requires a valid -listen address: %v", err)
+	}
+	if host != "" {
+		return "", errors.New("-bind-interface conflicts with the host in -listen")
+	}
+	ip, err := interfaceIP(*bindInterface)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// interfaceIP returns the first address of the named interface, preferring
+// IPv4.
+func interfaceIP(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -bind-interface %q: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var found net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if found == nil {
+			found = ipnet.IP
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", name)
+	}
+	return found, nil
+}
+
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return true
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// loopbackInterface returns the name of the loopback interface.
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestListenAddressWarning(t *testing.T) {
+	for _, tt := range []struct {
+		listen string
+		warn   bool
+	}{
+		{":8000", false},
+		{"0.0.0.0:8000", false},
+		{"127.0.0.1:8000", false},
+		{"203.0.113.1:8000", true},
+	} {
+		t.Run(tt.listen, func(t *testing.T) {
+			newTestFS(t)
+			logs := captureLog(t)
+			setFlag(t, "listen", tt.listen)
+			if errs := configErrors(); errs != "" {
+				t.Fatalf("unexpected errors:\n%s", errs)
+			}
+			if got := strings.Contains(logs.String(), "is not assigned to any interface"); got != tt.warn {
+				t.Fatalf("warning = %v, want %v: %s", got, tt.warn, logs)
+			}
+		})
+	}
+}
+
+func TestBindInterface(t *testing.T) {
+	lo := loopbackInterface(t)
+	newTestFS(t)
+	captureLog(t)
+	setFlag(t, "listen", ":8000")
+	setFlag(t, "bind-interface", lo)
+	if errs := configErrors(); errs != "" {
+		t.Fatalf("unexpected errors:\n%s", errs)
+	}
+	if *listen != ":8000" {
+		t.Fatalf("validation changed -listen to %s", *listen)
+	}
+	addr, err := listenAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() || port != "8000" {
+		t.Fatalf("listen address = %s", addr)
+	}
+}
+
+func TestBindInterfaceErrors(t *testing.T) {
+	lo := loopbackInterface(t)
+	for _, tt := range []struct {
+		listen, iface, want string
+	}{
+		{"127.0.0.1:8000", lo, "-bind-interface conflicts"},
+		{":8000", "restfs-no-such-if", "invalid -bind-interface"},
+		{"8000", lo, "-bind-interface requires a valid -listen"},
+	} {
+		t.Run(tt.listen+"/"+tt.iface, func(t *testing.T) {
+			newTestFS(t)
+			setFlag(t, "listen", tt.listen)
+			setFlag(t, "bind-interface", tt.iface)
+			if errs := configErrors(); !strings.Contains(errs, tt.want) {
+				t.Fatalf("want error containing %q, got:\n%s", tt.want, errs)
+			}
+		})
+	}
+}
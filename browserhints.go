@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var (
+	acceptCH          = flag.String("accept-ch", "", "Client hints requested on HTML responses (comma-separated)")
+	permissionsPolicy = flag.String("permissions-policy", "", "Permissions-Policy header value for HTML responses")
+)
+
+func init() {
+	registerInspector(11, "browser_hints", func(r *http.Request, res *introspection) bool {
+		return *acceptCH != "" || *permissionsPolicy != ""
+	})
+	registerMiddleware(11, func(h http.Handler) http.Handler {
+		if *acceptCH == "" && *permissionsPolicy == "" {
+			return h
+		}
+
+		log.Print("Browser hint headers enabled for HTML responses")
+		return withBrowserHints(h)
+	})
+}
+
+func withBrowserHints(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&browserHintsWriter{ResponseWriter: w}, r)
+	})
+}
+
+type browserHintsWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *browserHintsWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.setHints()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *browserHintsWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *browserHintsWriter) setHints() {
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		return
+	}
+	if *acceptCH != "" {
+		w.Header().Set("Accept-CH", *acceptCH)
+	}
+	if *permissionsPolicy != "" {
+		w.Header().Set("Permissions-Policy", *permissionsPolicy)
+	}
+}
+
+// Flush forwards to the underlying writer so that streamed responses are not
+// held back.
+func (w *browserHintsWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBrowserHints(t *testing.T) {
+	setFlag(t, "accept-ch", "DPR,Width")
+	setFlag(t, "permissions-policy", "camera=()")
+	c := newTestFS(t)
+	h := withBrowserHints(c)
+	mustPut(t, c, "/index.html", "<!doctype html><p>hi</p>")
+	mustPut(t, c, "/data.json", "{}")
+	mustPut(t, c, "/page", "<html><body>sniffed</body></html>")
+
+	tests := []struct {
+		path string
+		html bool
+	}{
+		{"/index.html", true},
+		{"/page", true},
+		{"/data.json", false},
+		{"/missing", false},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		rec := do(h, "GET", tt.path, "")
+		ch, pp := rec.Header().Get("Accept-CH"), rec.Header().Get("Permissions-Policy")
+		if tt.html && (ch != "DPR,Width" || pp != "camera=()") {
+			t.Errorf("%s: Accept-CH = %q, Permissions-Policy = %q", tt.path, ch, pp)
+		}
+		if !tt.html && (ch != "" || pp != "") {
+			t.Errorf("%s (%s): hints set on a non-HTML response", tt.path, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestBrowserHintsSingleHeader(t *testing.T) {
+	setFlag(t, "permissions-policy", "microphone=()")
+	h := withBrowserHints(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	rec := do(h, "GET", "/", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if rec.Header().Get("Permissions-Policy") != "microphone=()" || rec.Header().Get("Accept-CH") != "" {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+}
+
+func TestBrowserHintsForwardFlush(t *testing.T) {
+	setFlag(t, "accept-ch", "DPR")
+	h := withBrowserHints(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped writer does not implement http.Flusher")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		f.Flush()
+		w.Write([]byte("<p>streamed</p>"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Fatal("Flush was not forwarded")
+	}
+	if rec.Header().Get("Accept-CH") != "DPR" || rec.Body.String() != "<p>streamed</p>" {
+		t.Fatalf("Accept-CH = %q, body = %q", rec.Header().Get("Accept-CH"), rec.Body)
+	}
+}
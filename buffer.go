@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	tmpfsBufferDir       = flag.String("tmpfs-buffer-dir", "", "Directory on a RAM disk used to buffer uploads")
+	tmpfsBufferThreshold = flag.Int64("tmpfs-buffer-threshold", 64<<20, "Flush the upload buffer when it holds this many bytes")
+	tmpfsFlushInterval   = flag.Duration("tmpfs-flush-interval", 5*time.Second, "Flush the upload buffer at this interval")
+)
+
+const bufferFlushWorkers = 4
+
+// writeBuf is nil unless uploads are buffered.
+var writeBuf *writeBuffer
+
+func init() {
+	registerValidator(func() error {
+		if *tmpfsBufferDir == "" {
+			return nil
+		}
+		buf, err := filepath.Abs(*tmpfsBufferDir)
+		if err != nil {
+			return err
+		}
+		data, err := filepath.Abs(dataDirPath())
+		if err != nil {
+			return err
+		}
+		if buf == data || strings.HasPrefix(buf, data+string(filepath.Separator)) {
+			return errors.New("-tmpfs-buffer-dir must not be inside the data directory")
+		}
+		return nil
+	})
+}
+
+// writeBuffer keeps uploaded files in a buffer directory, mirroring the
+// layout of the data directory, until they are moved there in the
+// background. Sidecars and parent directories are written to the data
+// directory right away.
+type writeBuffer struct {
+	root      string
+	dir       string
+	threshold int64
+	kick      chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]int64
+	bytes   int64
+}
+
+func newWriteBuffer(root, dir string, threshold int64) (*writeBuffer, error) {
+	b := &writeBuffer{
+		root:      path.Clean(root),
+		dir:       path.Clean(dir),
+		threshold: threshold,
+		kick:      make(chan struct{}, 1),
+		pending:   make(map[string]int64),
+	}
+	if err := os.MkdirAll(b.dir, 0777); err != nil {
+		return nil, err
+	}
+	// Pick up files left over from a previous run.
+	err := filepath.Walk(b.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), tempPrefix) {
+			return os.Remove(name)
+		}
+		rel, err := filepath.Rel(b.dir, name)
+		if err != nil {
+			return err
+		}
+		b.add(path.Join(b.root, filepath.ToSlash(rel)), fi.Size())
+		return nil
+	})
+	return b, err
+}
+
+func (b *writeBuffer) path(fullpath string) string {
+	return path.Join(b.dir, strings.TrimPrefix(fullpath, b.root))
+}
+
+func (b *writeBuffer) add(fullpath string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes += size - b.pending[fullpath]
+	b.pending[fullpath] = size
+	bufferQueueDepth.Set(float64(len(b.pending)))
+	if b.bytes >= b.threshold {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *writeBuffer) forget(fullpath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes -= b.pending[fullpath]
+	delete(b.pending, fullpath)
+	bufferQueueDepth.Set(float64(len(b.pending)))
+}
+
+// locate returns where the current content of fullpath is stored.
+func (b *writeBuffer) locate(fullpath string) string {
+	if b == nil {
+		return fullpath
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pending[fullpath]; ok {
+		return b.path(fullpath)
+	}
+	return fullpath
+}
+
+// drop discards the buffered copy of fullpath. The caller must hold the
+// path lock.
+func (b *writeBuffer) drop(fullpath string) error {
+	if b == nil {
+		return nil
+	}
+	if err := os.Remove(b.path(fullpath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b.forget(fullpath)
+	return nil
+}
+
+// dropAll discards buffered copies of all files under dir.
+func (b *writeBuffer) dropAll(dir string) error {
+	if b == nil {
+		return nil
+	}
+	for _, fullpath := range b.pendingPaths() {
+		if !strings.HasPrefix(fullpath, dir+"/") {
+			continue
+		}
+		unlock := pathLocks.lock(fullpath)
+		err := b.drop(fullpath)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingIn returns the stats of the buffered files directly in dir, keyed
+// by name.
+func (b *writeBuffer) pendingIn(dir string) map[string]os.FileInfo {
+	if b == nil {
+		return nil
+	}
+	files := make(map[string]os.FileInfo)
+	for _, fullpath := range b.pendingPaths() {
+		if path.Dir(fullpath) != dir {
+			continue
+		}
+		if fi, err := os.Stat(b.path(fullpath)); err == nil {
+			files[fi.Name()] = fi
+		}
+	}
+	return files
+}
+
+// pendingOnly returns the buffered files below dir that have not been
+// flushed to the data directory before.
+func (b *writeBuffer) pendingOnly(dir string) []string {
+	if b == nil {
+		return nil
+	}
+	var paths []string
+	for _, fullpath := range b.pendingPaths() {
+		if !strings.HasPrefix(fullpath, dir+"/") {
+			continue
+		}
+		if _, err := os.Lstat(fullpath); os.IsNotExist(err) {
+			paths = append(paths, fullpath)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (b *writeBuffer) pendingPaths() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	paths := make([]string, 0, len(b.pending))
+	for fullpath := range b.pending {
+		paths = append(paths, fullpath)
+	}
+	return paths
+}
+
+func (b *writeBuffer) loop(interval time.Duration) {
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-tick:
+		case <-b.kick:
+		}
+		b.flush()
+	}
+}
+
+// flush moves all buffered files to the data directory.
+func (b *writeBuffer) flush() {
+	if b == nil {
+		return
+	}
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < bufferFlushWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fullpath := range paths {
+				if err := b.flushFile(fullpath); err != nil {
+					log.Printf("Failed to flush %s: %v", fullpath, err)
+				}
+			}
+		}()
+	}
+	for _, fullpath := range b.pendingPaths() {
+		paths <- fullpath
+	}
+	close(paths)
+	wg.Wait()
+}
+
+func (b *writeBuffer) flushFile(fullpath string) error {
+	unlock := pathLocks.lock(fullpath)
+	defer unlock()
+
+	bpath := b.path(fullpath)
+	f, err := os.Open(bpath)
+	if os.IsNotExist(err) {
+		b.forget(fullpath)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := writeFileAtomic(fullpath, f); err != nil {
+		return err
+	}
+	// Keep the upload time so that ETags and tombstone ordering are unchanged.
+	if err := os.Chtimes(fullpath, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	if err := os.Remove(bpath); err != nil {
+		return err
+	}
+	b.forget(fullpath)
+	return nil
+}
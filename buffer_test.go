@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withWriteBuffer buffers uploads to c for the duration of the test. Files
+// are only flushed when the test calls writeBuf.flush.
+func withWriteBuffer(t *testing.T, c *restfs) {
+	b, err := newWriteBuffer(c.dir, t.TempDir(), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBuf = b
+	t.Cleanup(func() { writeBuf = nil })
+}
+
+func TestWriteBufferServesBufferedFile(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/a.txt", "buffered")
+	if _, err := os.Stat(filepath.Join(c.dir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("upload was not buffered")
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Body.String() != "buffered" {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body)
+	}
+	writeBuf.flush()
+	if b, err := os.ReadFile(filepath.Join(c.dir, "a.txt")); err != nil || string(b) != "buffered" {
+		t.Fatalf("flushed file = %q, %v", b, err)
+	}
+}
+
+func TestWriteBufferPutAfterDelete(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/a.txt", "old")
+	writeBuf.flush()
+	time.Sleep(10 * time.Millisecond)
+	do(c, "DELETE", "/a.txt", "")
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, c, "/a.txt", "new")
+
+	if rec := do(c, "GET", "/a.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "new" {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body)
+	}
+
+	// A GC before the flush keeps the sidecars of the new upload.
+	fullpath := filepath.Join(c.dir, "a.txt")
+	tpath, tstat, err := findTombstone(fullpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collect(tpath, tstat); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fullpath + checksumSuffix); err != nil {
+		t.Fatalf("checksum sidecar was collected: %v", err)
+	}
+	writeBuf.flush()
+	rec := do(c, "GET", "/a.txt", "")
+	if rec.Body.String() != "new" || rec.Header().Get("X-Restfs-SHA256") == "" {
+		t.Fatalf("GET after flush = %q, checksum %q", rec.Body, rec.Header().Get("X-Restfs-SHA256"))
+	}
+}
+
+func TestWriteBufferListing(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/dir/flushed", "1")
+	writeBuf.flush()
+	mustPut(t, c, "/dir/buffered", "2")
+	mustPut(t, c, "/dir/sub/deep", "3")
+
+	rec := do(c, "GET", "/dir/", "")
+	if got := strings.Fields(rec.Body.String()); strings.Join(got, " ") != "buffered flushed sub/" {
+		t.Fatalf("listing = %v", got)
+	}
+}
+
+func TestWriteBufferCopyTree(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/src/flushed", "1")
+	writeBuf.flush()
+	mustPut(t, c, "/src/buffered", "2")
+	mustPut(t, c, "/src/sub/deep", "3")
+
+	req := newRequest("COPY", "/src", "")
+	req.Header.Set("Destination", "/dst")
+	req.Header.Set("Depth", "infinity")
+	if rec := serve(c, req); rec.Code != http.StatusCreated {
+		t.Fatalf("COPY = %d %s", rec.Code, rec.Body)
+	}
+	for p, want := range map[string]string{"/dst/flushed": "1", "/dst/buffered": "2", "/dst/sub/deep": "3"} {
+		if rec := do(c, "GET", p, ""); rec.Body.String() != want {
+			t.Errorf("GET %s = %d %q", p, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestWriteBufferDeleteBufferedOnly(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/a.txt", "x")
+	if rec := do(c, "DELETE", "/a.txt", ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE = %d", rec.Code)
+	}
+	fullpath := filepath.Join(c.dir, "a.txt")
+	if _, err := os.Stat(fullpath + checksumSuffix); !os.IsNotExist(err) {
+		t.Fatal("sidecar of a never flushed file was left behind")
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET = %d", rec.Code)
+	}
+	writeBuf.flush()
+	if _, err := os.Stat(fullpath); !os.IsNotExist(err) {
+		t.Fatal("deleted upload was flushed")
+	}
+}
+
+func TestWriteBufferValidatorHasNoSideEffects(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buffer")
+	setFlag(t, "data-dir", t.TempDir())
+	setFlag(t, "tmpfs-buffer-dir", dir)
+	if errs := validateConfig(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("validation created the buffer directory")
+	}
+}
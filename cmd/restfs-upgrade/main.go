@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	dataDir = flag.String("data-dir", "./data", "Data directory")
+	dryRun  = flag.Bool("dry-run", false, "Show what would be created without writing")
+
+	tombstoneHMACSecret = flag.String("tombstone-hmac-secret", "", "Secret the server uses to name tombstones")
+)
+
+// These must be kept in sync with the server: tombstone and tempPrefix in
+// main.go, the sidecar suffixes in sidecar.go, accesscount.go and
+// upstream.go, and indexSuffix as indexFile in index.go.
+const (
+	tombstone      = ".restfs-deleted"
+	tempPrefix     = ".restfs-tmp-"
+	checksumSuffix = ".restfs-sha256"
+	metaSuffix     = ".restfs-meta"
+	accessSuffix   = ".restfs-access-count"
+	upstreamSuffix = ".restfs-upstream"
+	indexSuffix    = ".restfs-index.json"
+	versionFile    = ".restfs-version"
+)
+
+type migration struct {
+	version int
+	desc    string
+	run     func(dir string) (int, error)
+}
+
+// migrations upgrade a data directory to the given version. Directories
+// without a version file are version 1.
+var migrations = []migration{
+	{2, "create checksum sidecars", addChecksums},
+}
+
+func main() {
+	flag.Parse()
+	if err := upgrade(*dataDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// upgrade runs the migrations newer than the version of dir.
+func upgrade(dir string) error {
+	current, err := readVersion(dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Data directory %s is at version %d", dir, current)
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Upgrading to version %d: %s", m.version, m.desc)
+		n, err := m.run(dir)
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			log.Printf("%d files would be upgraded", n)
+			continue
+		}
+		log.Printf("%d files upgraded", n)
+		if err := writeVersion(dir, m.version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readVersion(dir string) (int, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, versionFile))
+	if os.IsNotExist(err) {
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func writeVersion(dir string, version int) error {
+	return ioutil.WriteFile(filepath.Join(dir, versionFile), []byte(strconv.Itoa(version)+"\n"), 0666)
+}
+
+// isReserved must be kept in sync with isReserved in the server's sidecar.go.
+func isReserved(name string) bool {
+	return name == versionFile || strings.HasPrefix(name, tempPrefix) ||
+		strings.HasSuffix(name, tombstone) || strings.HasSuffix(name, checksumSuffix) || strings.HasSuffix(name, metaSuffix) ||
+		strings.HasSuffix(name, accessSuffix) || strings.HasSuffix(name, upstreamSuffix) ||
+		strings.HasSuffix(name, indexSuffix) || isHMACTombstone(name)
+}
+
+// isHMACTombstone matches tombstones named with -tombstone-hmac-secret. It
+// must be kept in sync with tombstoneTarget in the server's tombstone.go.
+func isHMACTombstone(name string) bool {
+	i := strings.LastIndex(name, ".")
+	if *tombstoneHMACSecret == "" || i < 0 || len(name)-i-1 != 32 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(*tombstoneHMACSecret))
+	mac.Write([]byte(path.Base(name[:i])))
+	return hmac.Equal([]byte(name[i+1:]), []byte(hex.EncodeToString(mac.Sum(nil))[:32]))
+}
+
+func addChecksums(dir string) (int, error) {
+	var n int
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Symlinks are skipped; one to a directory would fail to read.
+		if !fi.Mode().IsRegular() || isReserved(fi.Name()) {
+			return nil
+		}
+		if s, err := os.Stat(name + checksumSuffix); err == nil && !fi.ModTime().After(s.ModTime()) {
+			return nil
+		}
+		n++
+		if *dryRun {
+			log.Printf("Would create %s", name+checksumSuffix)
+			return nil
+		}
+		return writeChecksum(name)
+	})
+	return n, err
+}
+
+func writeChecksum(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"sha256": hex.EncodeToString(hash.Sum(nil)),
+		"size":   size,
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+checksumSuffix, b, 0666)
+}
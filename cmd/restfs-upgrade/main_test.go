@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newOldDataDir returns a data directory as written by a version 1 server.
+func newOldDataDir(t *testing.T) string {
+	t.Helper()
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"a.txt":                       "aaa",
+		"sub/b.txt":                   "bb",
+		"sub/deleted.txt":             "gone",
+		"sub/deleted.txt" + tombstone: "",
+		"c.txt" + metaSuffix:          `{"content_type":"text/plain"}`,
+		"c.txt":                       "c",
+		tempPrefix + "1-1":            "partial",
+		"sub/" + indexSuffix:          "{}",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(body), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// sidecars returns the names of the checksum sidecars below dir.
+func sidecars(t *testing.T, dir string) map[string]bool {
+	t.Helper()
+	found := make(map[string]bool)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+checksumSuffix))
+	sub, _ := filepath.Glob(filepath.Join(dir, "*", "*"+checksumSuffix))
+	for _, m := range append(matches, sub...) {
+		rel, _ := filepath.Rel(dir, m)
+		found[filepath.ToSlash(rel)] = true
+	}
+	return found
+}
+
+func setDryRun(t *testing.T, v bool) {
+	old := *dryRun
+	*dryRun = v
+	t.Cleanup(func() { *dryRun = old })
+}
+
+func TestUpgrade(t *testing.T) {
+	dir := newOldDataDir(t)
+	if err := upgrade(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a.txt", "sub/b.txt", "sub/deleted.txt", "c.txt"}
+	got := sidecars(t, dir)
+	if len(got) != len(want) {
+		t.Fatalf("sidecars = %v, want one for each of %v", got, want)
+	}
+	for _, name := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name+checksumSuffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		var sum struct {
+			SHA256 string `json:"sha256"`
+			Size   int64  `json:"size"`
+		}
+		if err := json.Unmarshal(b, &sum); err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256(data)
+		if sum.SHA256 != hex.EncodeToString(h[:]) || sum.Size != int64(len(data)) {
+			t.Errorf("%s: sidecar = %+v", name, sum)
+		}
+	}
+	if v, err := readVersion(dir); err != nil || v != 2 {
+		t.Fatalf("version = %d, %v", v, err)
+	}
+
+	// An upgraded directory is left alone.
+	os.Remove(filepath.Join(dir, "a.txt"+checksumSuffix))
+	if err := upgrade(dir); err != nil {
+		t.Fatal(err)
+	}
+	if sidecars(t, dir)["a.txt"+checksumSuffix] {
+		t.Fatal("migration ran again on an upgraded directory")
+	}
+}
+
+func TestUpgradeDryRun(t *testing.T) {
+	dir := newOldDataDir(t)
+	setDryRun(t, true)
+	n, err := addChecksums(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("%d files would be upgraded, want 4", n)
+	}
+	if err := upgrade(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := sidecars(t, dir); len(got) != 0 {
+		t.Fatalf("dry run created %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, versionFile)); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote the version file: %v", err)
+	}
+}
+
+func TestAddChecksumsSkipsCurrent(t *testing.T) {
+	dir := newOldDataDir(t)
+	if _, err := addChecksums(dir); err != nil {
+		t.Fatal(err)
+	}
+	// A file changed after its sidecar gets a new one.
+	later := time.Now().Add(time.Hour)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0666)
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	n, err := addChecksums(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("%d files upgraded, want 1", n)
+	}
+}
+
+func TestReadVersion(t *testing.T) {
+	dir := t.TempDir()
+	if v, err := readVersion(dir); err != nil || v != 1 {
+		t.Fatalf("missing version file: %d, %v", v, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, versionFile), []byte("x\n"), 0666)
+	if _, err := readVersion(dir); err == nil {
+		t.Fatal("invalid version file accepted")
+	}
+	if err := writeVersion(dir, 3); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := readVersion(dir); err != nil || v != 3 {
+		t.Fatalf("version = %d, %v", v, err)
+	}
+}
+
+func TestAddChecksumsSkipsSymlinks(t *testing.T) {
+	dir := newOldDataDir(t)
+	if err := os.Symlink("sub", filepath.Join(dir, "dir-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "file-link")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := addChecksums(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir-link", "file-link"} {
+		if _, err := os.Lstat(filepath.Join(dir, name+checksumSuffix)); !os.IsNotExist(err) {
+			t.Errorf("sidecar created for symlink %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt"+checksumSuffix)); err != nil {
+		t.Errorf("symlink target has no sidecar: %v", err)
+	}
+}
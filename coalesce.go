@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+)
+
+// flightGroup runs at most one lookup per key at a time. Callers arriving
+// while a lookup is in flight wait for and share its result, like
+// golang.org/x/sync/singleflight.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg       sync.WaitGroup
+	val      *fileLookup
+	panicked interface{}
+}
+
+func (g *flightGroup) do(key string, fn func() *fileLookup) (v *fileLookup, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.val, true
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// Waiters must be released even if fn panics; they panic as well.
+	defer func() {
+		if v := recover(); v != nil {
+			c.panicked = v
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+	}()
+	c.val = fn()
+	return c.val, false
+}
+
+type fileLookup struct {
+	stat  os.FileInfo
+	ctype string
+	data  []byte // contents from the file cache, if cached
+}
+
+var lookups flightGroup
+
+// lookupFile stats fullpath, detects its content type and loads it from the
+// file cache, sharing the work among concurrent requests for the same path.
+func lookupFile(fullpath string) *fileLookup {
+	lk, shared := lookups.do(fullpath, func() *fileLookup {
+		lk := &fileLookup{stat: stat(fullpath)}
+		if lk.stat != nil && !lk.stat.IsDir() {
+			lk.data = cachedContent(fullpath, lk.stat)
+			lk.ctype = detectContentType(fullpath, lk.data)
+		}
+		return lk
+	})
+	if shared {
+		coalescedRequests.Inc()
+	}
+	return lk
+}
+
+// detectContentType works like contentType but sniffs the first 512 bytes
+// of the file when the extension is unknown, as http.ServeContent does. The
+// contents are read from data instead of the file if not nil.
+func detectContentType(fullpath string, data []byte) string {
+	var m metadata
+	if err := readSidecar(fullpath, metaSuffix, &m); err == nil && m.ContentType != "" {
+		return m.ContentType
+	}
+	if ctype := mime.TypeByExtension(path.Ext(fullpath)); ctype != "" {
+		return ctype
+	}
+	if data != nil {
+		if len(data) > 512 {
+			data = data[:512]
+		}
+		return http.DetectContentType(data)
+	}
+	f, err := os.Open(writeBuf.locate(fullpath))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, _ := io.ReadFull(f, buf[:])
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupSharesResult(t *testing.T) {
+	var g flightGroup
+	var calls, shared int32
+	release := make(chan struct{})
+	want := &fileLookup{ctype: "text/plain"}
+
+	const n = 100
+	var started, wg sync.WaitGroup
+	started.Add(n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			started.Done()
+			v, s := g.do("key", func() *fileLookup {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return want
+			})
+			if v != want {
+				t.Error("unexpected result")
+			}
+			if s {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	started.Wait()
+	// Let the goroutines queue up behind the first call.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls < 1 || calls+shared != n {
+		t.Fatalf("calls = %d, shared = %d", calls, shared)
+	}
+	if calls > n/2 {
+		t.Fatalf("%d of %d lookups were not coalesced", calls, n)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	waiter := make(chan interface{})
+
+	go func() {
+		defer func() { recover() }()
+		g.do("key", func() *fileLookup {
+			close(entered)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-entered
+	go func() {
+		defer func() { waiter <- recover() }()
+		g.do("key", func() *fileLookup { return nil })
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case v := <-waiter:
+		if v != "boom" {
+			t.Fatalf("waiter recovered %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter blocked after a panic")
+	}
+	want := &fileLookup{}
+	if v, _ := g.do("key", func() *fileLookup { return want }); v != want {
+		t.Fatal("key was not cleared after a panic")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "file-cache-size", "1024")
+	setFlag(t, "file-cache-max-file", "16")
+	mustPut(t, c, "/small.txt", "cached")
+	mustPut(t, c, "/large.txt", "this file is too large")
+
+	if rec := do(c, "GET", "/small.txt", ""); rec.Body.String() != "cached" {
+		t.Fatalf("body = %q", rec.Body)
+	}
+	fullpath := filepath.Join(c.dir, "small.txt")
+	fileContents.mu.Lock()
+	_, ok := fileContents.items[fullpath]
+	fileContents.mu.Unlock()
+	if !ok {
+		t.Fatal("small file was not cached")
+	}
+	if rec := do(c, "GET", "/small.txt", ""); rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+
+	// An overwrite invalidates the entry, even with the same size and an
+	// mtime too coarse to change.
+	fi, _ := os.Stat(fullpath)
+	mustPut(t, c, "/small.txt", "CACHED")
+	os.Chtimes(fullpath, fi.ModTime(), fi.ModTime())
+	if rec := do(c, "GET", "/small.txt", ""); rec.Body.String() != "CACHED" {
+		t.Fatalf("body = %q after same-size overwrite", rec.Body)
+	}
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, c, "/small.txt", "updated")
+	if rec := do(c, "GET", "/small.txt", ""); rec.Body.String() != "updated" {
+		t.Fatalf("body = %q after overwrite", rec.Body)
+	}
+
+	do(c, "GET", "/large.txt", "")
+	fileContents.mu.Lock()
+	_, ok = fileContents.items[filepath.Join(c.dir, "large.txt")]
+	fileContents.mu.Unlock()
+	if ok {
+		t.Fatal("large file was cached")
+	}
+}
+
+func TestFileCacheEviction(t *testing.T) {
+	fc := newFileCache()
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, []byte("1234"), 0666)
+		fi, _ := os.Stat(p)
+		fc.add(name, fi, []byte("1234"), 8)
+	}
+	if fc.used != 8 || len(fc.items) != 2 {
+		t.Fatalf("used = %d, items = %d", fc.used, len(fc.items))
+	}
+	if _, ok := fc.items["a"]; ok {
+		t.Fatal("least recently used entry was kept")
+	}
+}
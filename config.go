@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var createDataDir = flag.Bool("create-data-dir", false, "Create data directory at startup instead of on the first write")
+
+var validators []func() error
+
+// registerValidator adds a startup check run by validateConfig after flags are
+// parsed. The check returns nil if the configuration is acceptable. Checks
+// must not change anything on disk.
+func registerValidator(check func() error) {
+	validators = append(validators, check)
+}
+
+func init() {
+	registerValidator(validateDataDir)
+	registerValidator(validateAccessLog)
+	registerValidator(func() error {
+		if _, _, err := net.SplitHostPort(*listen); err != nil {
+			return fmt.Errorf("invalid -listen address %q: %v", *listen, err)
+		}
+		return nil
+	})
+	registerValidator(func() error {
+		if *gcInterval < 0 {
+			return fmt.Errorf("-gc-interval must not be negative: %s", *gcInterval)
+		}
+		return nil
+	})
+	registerValidator(func() error {
+		if *gracefulTimeout < 0 {
+			return fmt.Errorf("-graceful-timeout must not be negative: %s", *gracefulTimeout)
+		}
+		return nil
+	})
+}
+
+// validateConfig runs all registered checks and returns every violation found.
+func validateConfig() []error {
+	var errs []error
+	for _, check := range validators {
+		if err := check(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateDataDir accepts a missing data directory; it is created on the
+// first write, or at startup with -create-data-dir.
+func validateDataDir() error {
+	fi, err := os.Stat(dataDirPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("data directory %s is not a directory", dataDirPath())
+	}
+	return nil
+}
+
+func validateAccessLog() error {
+	if *accessLog == "-" {
+		return nil
+	}
+	target := *accessLog
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		target = filepath.Dir(target)
+	}
+	if err := syscall.Access(target, 2 /* W_OK */); err != nil {
+		return fmt.Errorf("access log %s is not writable: %v", *accessLog, err)
+	}
+	return nil
+}
+
+// addrConflicts reports whether two listen addresses would bind the same port.
+func addrConflicts(a, b string) bool {
+	ahost, aport, err := net.SplitHostPort(a)
+	if err != nil {
+		return false
+	}
+	bhost, bport, err := net.SplitHostPort(b)
+	if err != nil || aport != bport {
+		return false
+	}
+	return ahost == bhost || isWildcardHost(ahost) || isWildcardHost(bhost)
+}
+
+func isWildcardHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func configErrors() string {
+	var msgs []string
+	for _, err := range validateConfig() {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func TestValidateConfigDefaults(t *testing.T) {
+	newTestFS(t)
+	if errs := configErrors(); errs != "" {
+		t.Fatalf("unexpected errors:\n%s", errs)
+	}
+}
+
+func TestValidateConfigRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]string
+		want  string
+	}{
+		{"cert without key", map[string]string{"tls-cert": "cert.pem"}, "-tls-cert and -tls-key"},
+		{"key without cert", map[string]string{"tls-key": "key.pem"}, "-tls-cert and -tls-key"},
+		{"wildcard cors with credentials", map[string]string{"cors-origins": "*", "cors-allow-credentials": "true"}, "wildcard origin"},
+		{"empty cors origin", map[string]string{"cors-origins": "a,,b"}, "empty origin"},
+		{"negative gc interval", map[string]string{"gc-interval": "-1s"}, "-gc-interval"},
+		{"negative graceful timeout", map[string]string{"graceful-timeout": "-1s"}, "-graceful-timeout"},
+		{"invalid listen", map[string]string{"listen": "8000"}, "invalid -listen"},
+		{"prometheus conflicts with listen", map[string]string{"listen": ":8000", "prometheus": "127.0.0.1:8000"}, "conflicts with -listen"},
+		{"invalid admin listen", map[string]string{"admin-listen": "localhost"}, "invalid -admin-listen"},
+		{"admin listen conflicts with listen", map[string]string{"listen": ":8000", "admin-listen": "127.0.0.1:8000"}, "conflicts with -listen"},
+		{"admin listen conflicts with prometheus", map[string]string{"prometheus": ":9000", "admin-listen": ":9000"}, "conflicts with -prometheus"},
+		{"invalid admin allow address", map[string]string{"admin-allow": "10.0.0.1,not-an-ip"}, "invalid -admin-allow entry"},
+		{"invalid admin allow cidr", map[string]string{"admin-allow": "10.0.0.0/33"}, "invalid -admin-allow entry"},
+		{"presign without base url", map[string]string{"presign-secret": "s"}, "requires -presign-base-url"},
+		{"relative presign base url", map[string]string{"presign-secret": "s", "presign-base-url": "/files"}, "invalid -presign-base-url"},
+		{"unwritable access log", map[string]string{"access-log": "/nonexistent/dir/access.log"}, "not writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestFS(t)
+			t.Cleanup(func() { prometheusAddrs = nil })
+			for name, value := range tt.flags {
+				setFlag(t, name, value)
+			}
+			if errs := configErrors(); !strings.Contains(errs, tt.want) {
+				t.Fatalf("want error containing %q, got:\n%s", tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestValidateDataDir(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing")
+	setFlag(t, "data-dir", missing)
+	if err := validateDataDir(); err != nil {
+		t.Fatalf("missing data dir should be accepted: %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatal("validation must not create the data dir")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "data-dir", file)
+	if err := validateDataDir(); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("want not a directory error, got %v", err)
+	}
+}
+
+func TestValidateAccessLogHasNoSideEffects(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "access.log")
+	setFlag(t, "access-log", name)
+	if err := validateAccessLog(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatal("validation must not create the access log")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+var connStates = struct {
+	sync.Mutex
+	m map[net.Conn]http.ConnState
+}{m: make(map[net.Conn]http.ConnState)}
+
+// trackConnState counts connection lifecycle events. A connection becoming
+// active after being idle has been reused through keep-alive. Connections
+// closed before serving a request count as neither closed_idle nor
+// closed_active.
+func trackConnState(conn net.Conn, state http.ConnState) {
+	connStates.Lock()
+	prev, seen := connStates.m[conn]
+	switch state {
+	case http.StateNew:
+		connStates.m[conn] = state
+	case http.StateActive, http.StateIdle:
+		if seen {
+			connStates.m[conn] = state
+		}
+	case http.StateClosed, http.StateHijacked:
+		delete(connStates.m, conn)
+	}
+	connStates.Unlock()
+
+	switch {
+	case state == http.StateNew:
+		connections.WithLabelValues("new").Inc()
+	case state == http.StateActive && prev == http.StateIdle:
+		connections.WithLabelValues("reused").Inc()
+	case state == http.StateClosed && prev == http.StateIdle:
+		connections.WithLabelValues("closed_idle").Inc()
+	case state == http.StateClosed && prev == http.StateActive:
+		connections.WithLabelValues("closed_active").Inc()
+	}
+}
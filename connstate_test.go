@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func connCounts(t *testing.T) map[string]float64 {
+	m := make(map[string]float64)
+	for _, state := range []string{"new", "reused", "closed_idle", "closed_active"} {
+		m[state] = counterValue(t, connections.WithLabelValues(state))
+	}
+	return m
+}
+
+func TestConnStateReuse(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = trackConnState
+	srv.Start()
+	defer srv.Close()
+
+	before := connCounts(t)
+	var transports []*http.Transport
+	for i := 0; i < 3; i++ {
+		transports = append(transports, &http.Transport{MaxConnsPerHost: 1})
+	}
+	for i := 0; i < 10; i++ {
+		client := &http.Client{Transport: transports[i%3]}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	for _, tr := range transports {
+		tr.CloseIdleConnections()
+	}
+	// A connection that never sends a request.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return connCounts(t)["new"]-before["new"] == 4 })
+	conn.Close()
+	srv.Close()
+
+	after := connCounts(t)
+	want := map[string]float64{"new": 4, "reused": 7, "closed_idle": 3, "closed_active": 0}
+	for state, n := range want {
+		if got := after[state] - before[state]; got != n {
+			t.Errorf("%s = %v, want %v", state, got, n)
+		}
+	}
+}
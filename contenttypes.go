@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const contentTypeSampleSize = 10000
+
+var contentTypeBuckets = []string{"application/*", "image/*", "text/*", "other"}
+
+func init() {
+	registerGCHook(func(dir string) {
+		if !prometheusEnabled() {
+			return
+		}
+		if err := updateContentTypeStats(dir); err != nil {
+			log.Printf("Failed to update content type stats: %v", err)
+		}
+	})
+}
+
+// updateContentTypeStats estimates the number of stored files per content
+// type bucket from a uniform sample of live files.
+func updateContentTypeStats(dir string) error {
+	var (
+		sample []string
+		total  int
+	)
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || isReserved(fi.Name()) || stat(name) == nil {
+			return nil
+		}
+		total++
+		if len(sample) < contentTypeSampleSize {
+			sample = append(sample, name)
+		} else if i := rand.Intn(total); i < contentTypeSampleSize {
+			sample[i] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, name := range sample {
+		counts[contentTypeBucket(contentType(name))]++
+	}
+	for _, bucket := range contentTypeBuckets {
+		var estimate float64
+		if len(sample) > 0 {
+			estimate = float64(counts[bucket]) * float64(total) / float64(len(sample))
+		}
+		storedFilesByType.WithLabelValues(bucket).Set(estimate)
+	}
+	return nil
+}
+
+func contentTypeBucket(ctype string) string {
+	for _, prefix := range []string{"application/", "image/", "text/"} {
+		if strings.HasPrefix(ctype, prefix) {
+			return prefix + "*"
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func storedFiles(t *testing.T) map[string]float64 {
+	t.Helper()
+	counts := make(map[string]float64)
+	for _, bucket := range contentTypeBuckets {
+		counts[bucket] = gaugeValue(t, storedFilesByType.WithLabelValues(bucket))
+	}
+	return counts
+}
+
+func TestContentTypeStats(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.json", "{}")
+	mustPut(t, c, "/dir/b.json", "[]")
+	mustPut(t, c, "/c.png", "png")
+	mustPut(t, c, "/d.txt", "text")
+	mustPut(t, c, "/e.unknownext", "bin")
+	r := newRequest("PUT", "/f.bin", "video")
+	r.Header.Set("Content-Type", "video/mp4")
+	serve(c, r)
+	mustPut(t, c, "/deleted.json", "{}")
+	do(c, "DELETE", "/deleted.json", "")
+
+	if err := updateContentTypeStats(c.dir); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"application/*": 3, "image/*": 1, "text/*": 1, "other": 1}
+	got := storedFiles(t)
+	for bucket, n := range want {
+		if got[bucket] != n {
+			t.Fatalf("stored files = %v, want %v", got, want)
+		}
+	}
+
+	// The gauges follow deletions on the next scan.
+	do(c, "DELETE", "/a.json", "")
+	do(c, "DELETE", "/c.png", "")
+	if err := updateContentTypeStats(c.dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedFiles(t); got["application/*"] != 2 || got["image/*"] != 0 {
+		t.Fatalf("stored files after deletes = %v", got)
+	}
+}
+
+func TestContentTypeStatsAfterGC(t *testing.T) {
+	c := newTestFS(t)
+	prometheusAddrs = addrList{"127.0.0.1:0"}
+	t.Cleanup(func() { prometheusAddrs = nil })
+	mustPut(t, c, "/a.png", "png")
+	mustPut(t, c, "/b.png", "png")
+	storedFilesByType.WithLabelValues("image/*").Set(0)
+
+	c.gc = newGC(c.dir, []string{c.dir})
+	defer close(c.gc.invoke)
+	c.gc.Start()
+	waitFor(t, func() bool { return gaugeValue(t, storedFilesByType.WithLabelValues("image/*")) == 2 })
+}
+
+func TestContentTypeBucket(t *testing.T) {
+	for ctype, want := range map[string]string{
+		"application/json":          "application/*",
+		"image/png":                 "image/*",
+		"text/plain; charset=utf-8": "text/*",
+		"video/mp4":                 "other",
+		"":                          "other",
+	} {
+		if got := contentTypeBucket(ctype); got != want {
+			t.Errorf("%q: %s, want %s", ctype, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	cookieAuthSecret = flag.String("cookie-auth-secret", "", "Accept session tokens in a cookie signed with this secret")
+	cookieAuthName   = flag.String("cookie-auth-name", "restfs_session", "Name of the session cookie")
+)
+
+var errInvalidSession = errors.New("invalid session token")
+
+// sessionToken is the payload of a session cookie. The cookie value is
+// base64url(payload) + "." + base64url(HMAC-SHA256(secret, payload)).
+//
+// Browsers only send the cookie on cross-site requests when it was set with
+// SameSite=None; Secure and the request is made with credentials, which in
+// turn requires -cors-allow-credentials.
+type sessionToken struct {
+	User    string   `json:"user"`
+	Roles   []string `json:"roles,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+func init() {
+	registerInspector(19, "cookie_auth", func(r *http.Request, res *introspection) bool {
+		if *cookieAuthSecret == "" {
+			return false
+		}
+		if _, err := r.Cookie(*cookieAuthName); err == nil {
+			res.WouldAuthenticate = true
+			res.AuthMethod = "cookie"
+		}
+		return true
+	})
+	registerMiddleware(19, func(h http.Handler) http.Handler {
+		if *cookieAuthSecret == "" {
+			return h
+		}
+
+		log.Printf("Cookie authentication enabled with cookie %s", *cookieAuthName)
+		return withCookieAuth(h, []byte(*cookieAuthSecret), *cookieAuthName)
+	})
+}
+
+// withCookieAuth sets the auth identity from a valid session cookie. With
+// -auth-callback-url the identity is only passed to the callback, which still
+// authorizes the request. Requests without the cookie are passed on unchanged
+// so that header based authentication still applies.
+func withCookieAuth(h http.Handler, secret []byte, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		tok, err := decodeSession(secret, c.Value, time.Now())
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		d := &authDecision{Allowed: true, User: tok.User, Roles: tok.Roles}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, d)))
+	})
+}
+
+func decodeSession(secret []byte, value string, now time.Time) (*sessionToken, error) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return nil, errInvalidSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value[:i])
+	if err != nil {
+		return nil, errInvalidSession
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return nil, errInvalidSession
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidSession
+	}
+	var tok sessionToken
+	if err := json.Unmarshal(payload, &tok); err != nil || tok.User == "" {
+		return nil, errInvalidSession
+	}
+	if tok.Expires != 0 && now.Unix() >= tok.Expires {
+		return nil, errInvalidSession
+	}
+	return &tok, nil
+}
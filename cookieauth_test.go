@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testCookieSecret = []byte("cookie secret")
+
+func sessionCookie(t *testing.T, tok *sessionToken) *http.Cookie {
+	t.Helper()
+	payload, err := json.Marshal(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, testCookieSecret)
+	mac.Write(payload)
+	v := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return &http.Cookie{Name: "restfs_session", Value: v}
+}
+
+// identityHandler writes the user attached to the request.
+var identityHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if d := authIdentity(r); d != nil {
+		w.Write([]byte(d.User))
+	}
+})
+
+func TestCookieAuthStillAuthorizes(t *testing.T) {
+	var seen []authRequest
+	h := withTestAuth(t, identityHandler, func(req *authRequest) bool {
+		seen = append(seen, *req)
+		return req.User == "alice" && req.Path == "/public"
+	})
+	h = withCookieAuth(h, testCookieSecret, "restfs_session")
+
+	r := newRequest("GET", "/private", "")
+	r.AddCookie(sessionCookie(t, &sessionToken{User: "bob", Roles: []string{"reader"}}))
+	if rec := serve(h, r); rec.Code != http.StatusForbidden {
+		t.Fatalf("authenticated but unauthorized: %d, want 403", rec.Code)
+	}
+	if len(seen) != 1 || seen[0].User != "bob" || len(seen[0].Roles) != 1 || seen[0].Roles[0] != "reader" {
+		t.Fatalf("callback saw %+v", seen)
+	}
+
+	r = newRequest("GET", "/public", "")
+	r.AddCookie(sessionCookie(t, &sessionToken{User: "alice"}))
+	rec := serve(h, r)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Fatalf("authorized: %d %q", rec.Code, rec.Body)
+	}
+
+	// Another user must not hit the decision cached for alice.
+	r = newRequest("GET", "/public", "")
+	r.AddCookie(sessionCookie(t, &sessionToken{User: "bob"}))
+	if rec := serve(h, r); rec.Code != http.StatusForbidden {
+		t.Fatalf("cached decision reused for another user: %d", rec.Code)
+	}
+}
+
+func TestCookieAuthInvalid(t *testing.T) {
+	h := withCookieAuth(identityHandler, testCookieSecret, "restfs_session")
+	for name, c := range map[string]*http.Cookie{
+		"expired":  sessionCookie(t, &sessionToken{User: "alice", Expires: time.Now().Add(-time.Minute).Unix()}),
+		"no user":  sessionCookie(t, &sessionToken{}),
+		"tampered": {Name: "restfs_session", Value: "eyJ1c2VyIjoiYWxpY2UifQ.AAAA"},
+	} {
+		r := newRequest("GET", "/a", "")
+		r.AddCookie(c)
+		if rec := serve(h, r); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: %d, want 401", name, rec.Code)
+		}
+	}
+	if rec := do(h, "GET", "/a", ""); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("no cookie: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestCookieAuthCORSCredentials(t *testing.T) {
+	setFlag(t, "cors-allow-credentials", "true")
+	h := CORS(withCookieAuth(identityHandler, testCookieSecret, "restfs_session"), "https://app.example.com")
+
+	r := newRequest("GET", "/a", "")
+	r.Header.Set("Origin", "https://app.example.com")
+	r.AddCookie(sessionCookie(t, &sessionToken{User: "alice"}))
+	rec := serve(h, r)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Fatalf("credentialed request: %d %q", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q", got)
+	}
+
+	setFlag(t, "cors-allow-credentials", "false")
+	h = CORS(identityHandler, "https://app.example.com")
+	r = newRequest("GET", "/a", "")
+	r.Header.Set("Origin", "https://app.example.com")
+	if got := serve(h, r).Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("credentials allowed without the flag: %q", got)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+var (
+	parallelCopyThreshold = flag.Int64("parallel-copy-threshold", 100<<20, "Copy files of at least this size in parallel chunks")
+	copyWorkers           = flag.Int("copy-workers", 4, "Number of goroutines used for a parallel copy")
+)
+
+const copyChunkSize = 8 << 20
+
+var errChecksumMismatch = errors.New("checksum mismatch after copy")
+
+func init() {
+	addCORSHeaders("Destination", "Overwrite", "Depth")
+}
+
+// serveCopy copies the file at fullpath to the path in the Destination
+// header. An existing destination is replaced unless Overwrite is F.
+func (c *restfs) serveCopy(w http.ResponseWriter, r *http.Request, fullpath string) {
+	c.writes.Add(1)
+	defer c.writes.Done()
+
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		http.Error(w, "Missing or invalid Destination header", http.StatusBadRequest)
+		return
+	}
+	dst := c.fullpath(u.Path)
+	if isReserved(path.Base(dst)) || dst == fullpath {
+		http.Error(w, "Invalid destination", http.StatusBadRequest)
+		return
+	}
+	// The destination is written as if by a PUT.
+	if code, msg := authorizePath(r, "PUT", path.Clean("/"+u.Path)); code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	s := stat(fullpath)
+	if s == nil || s.IsDir() {
+		if dirs := c.dirpaths(r.URL.Path); len(dirs) > 0 {
+			c.serveCopyTree(w, r, dirs, u.Path)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	unlock := pathLocks.lock(dst)
+	defer unlock()
+	ds := stat(dst)
+	if (ds != nil && ds.IsDir()) || len(c.dirpaths(u.Path)) > 0 {
+		http.Error(w, "Cannot overwrite directory", http.StatusBadRequest)
+		return
+	}
+	if p := c.fileAncestor(u.Path); p != "" {
+		http.Error(w, "File exists at "+p, http.StatusConflict)
+		return
+	}
+	if ds != nil && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	if !hasDiskSpace(c.dir, s.Size()) {
+		http.Error(w, "Insufficient storage", http.StatusInsufficientStorage)
+		return
+	}
+	if err := c.copyEntry(dst, fullpath, s.Size()); err != nil {
+		code, msg := classifyFSError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	if ds != nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// copyEntry copies the file src with its metadata to dst. The caller must
+// hold the path lock of dst.
+func (c *restfs) copyEntry(dst, src string, size int64) error {
+	_, err := c.copyFile(dst, src, size)
+	if err != nil {
+		return err
+	}
+	var m metadata
+	if readSidecar(src, metaSuffix, &m) == nil {
+		err = writeSidecar(dst, metaSuffix, &m)
+	} else if err = os.Remove(dst + metaSuffix); os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		changes.publish(dst)
+	}
+	return err
+}
+
+func (c *restfs) copyFile(dst, src string, size int64) (int64, error) {
+	start := time.Now()
+	if size < *parallelCopyThreshold || *copyWorkers < 2 {
+		f, err := os.Open(writeBuf.locate(src))
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		n, err := c.saveFile(dst, f)
+		copyDuration.WithLabelValues("serial").Observe(time.Since(start).Seconds())
+		return n, err
+	}
+	// The parallel copy goes straight to the data directory, so an older
+	// buffered upload must not be flushed over it later.
+	if err := writeBuf.drop(dst); err != nil {
+		return 0, err
+	}
+	err := parallelCopy(dst, src, size)
+	copyDuration.WithLabelValues("parallel").Observe(time.Since(start).Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// parallelCopy copies src to dst in chunks written concurrently at their
+// offsets and verifies the result against the checksum of src.
+func parallelCopy(dst, src string, size int64) error {
+	in, err := os.Open(writeBuf.locate(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dir, _ := path.Split(dst)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	tmp := tempPath(dir)
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = out.Truncate(size)
+	if err == nil {
+		err = copyChunks(out, in, size)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	var sum, want string
+	if err == nil {
+		sum, err = hashFile(tmp)
+	}
+	if err == nil {
+		want, err = fileChecksum(src)
+	}
+	if err == nil && sum != want {
+		err = errChecksumMismatch
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return writeSidecar(dst, checksumSuffix, &checksum{SHA256: sum, Size: size})
+}
+
+func copyChunks(out io.WriterAt, in io.ReaderAt, size int64) error {
+	offsets := make(chan int64)
+	errs := make(chan error, *copyWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < *copyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, copyChunkSize)
+			for off := range offsets {
+				n := int64(copyChunkSize)
+				if size-off < n {
+					n = size - off
+				}
+				if _, err := in.ReadAt(buf[:n], off); err != nil && err != io.EOF {
+					errs <- err
+					return
+				}
+				if _, err := out.WriteAt(buf[:n], off); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	var err error
+	for off := int64(0); off < size && err == nil; off += copyChunkSize {
+		select {
+		case offsets <- off:
+		case err = <-errs:
+		}
+	}
+	close(offsets)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
+
+// fileChecksum returns the SHA-256 of fullpath, preferring its sidecar.
+func fileChecksum(fullpath string) (string, error) {
+	var sum checksum
+	if err := readSidecar(fullpath, checksumSuffix, &sum); err == nil && sum.SHA256 != "" {
+		return sum.SHA256, nil
+	}
+	return hashFile(writeBuf.locate(fullpath))
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
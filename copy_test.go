@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func copyRequest(src, dst string) *http.Request {
+	req := newRequest("COPY", src, "")
+	req.Header.Set("Destination", dst)
+	return req
+}
+
+func TestCopy(t *testing.T) {
+	c := newTestFS(t)
+	req := newRequest("PUT", "/a.txt", "hello")
+	req.Header.Set("Content-Type", "text/x-test")
+	serve(c, req)
+
+	if rec := serve(c, copyRequest("/a.txt", "/b.txt")); rec.Code != http.StatusCreated {
+		t.Fatalf("COPY = %d %s", rec.Code, rec.Body)
+	}
+	rec := do(c, "GET", "/b.txt", "")
+	if rec.Body.String() != "hello" || rec.Header().Get("Content-Type") != "text/x-test" {
+		t.Fatalf("GET copy = %q, %s", rec.Body, rec.Header().Get("Content-Type"))
+	}
+
+	mustPut(t, c, "/a.txt", "world")
+	if rec := serve(c, copyRequest("/a.txt", "http://example.com/b.txt")); rec.Code != http.StatusNoContent {
+		t.Fatalf("COPY over existing = %d", rec.Code)
+	}
+	req = copyRequest("/a.txt", "/b.txt")
+	req.Header.Set("Overwrite", "F")
+	if rec := serve(c, req); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("COPY with Overwrite: F = %d", rec.Code)
+	}
+
+	for dst, want := range map[string]int{
+		"":                    http.StatusBadRequest,
+		"/a.txt":              http.StatusBadRequest,
+		"/x" + checksumSuffix: http.StatusBadRequest,
+	} {
+		if rec := serve(c, copyRequest("/a.txt", dst)); rec.Code != want {
+			t.Errorf("COPY to %q = %d, want %d", dst, rec.Code, want)
+		}
+	}
+	if rec := serve(c, copyRequest("/missing", "/c.txt")); rec.Code != http.StatusNotFound {
+		t.Errorf("COPY of missing file = %d", rec.Code)
+	}
+}
+
+func TestParallelCopy(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "parallel-copy-threshold", "1")
+	data := make([]byte, copyChunkSize*2+123)
+	rand.Read(data)
+	mustPut(t, c, "/big", string(data))
+
+	if rec := serve(c, copyRequest("/big", "/copy")); rec.Code != http.StatusCreated {
+		t.Fatalf("COPY = %d %s", rec.Code, rec.Body)
+	}
+	b, err := os.ReadFile(filepath.Join(c.dir, "copy"))
+	if err != nil || string(b) != string(data) {
+		t.Fatalf("copy differs: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if rec := do(c, "GET", "/copy", ""); rec.Header().Get("X-Restfs-SHA256") != hex.EncodeToString(sum[:]) {
+		t.Fatalf("checksum = %q", rec.Header().Get("X-Restfs-SHA256"))
+	}
+}
+
+func TestCopyAuthorizesDestination(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/public/a.txt", "a")
+	var checked []string
+	h := withTestAuth(t, c, func(req *authRequest) bool {
+		checked = append(checked, req.Method+" "+req.Path)
+		return !strings.HasPrefix(req.Path, "/secret/")
+	})
+
+	if rec := serve(h, copyRequest("/public/a.txt", "/secret/b.txt")); rec.Code != http.StatusForbidden {
+		t.Fatalf("COPY to denied destination = %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "secret")); !os.IsNotExist(err) {
+		t.Fatal("denied destination was written")
+	}
+	if want := "COPY /public/a.txt,PUT /secret/b.txt"; strings.Join(checked, ",") != want {
+		t.Fatalf("checked %v, want %s", checked, want)
+	}
+	if rec := serve(h, copyRequest("/public/a.txt", "/public/../public/b.txt")); rec.Code != http.StatusCreated {
+		t.Fatalf("COPY to allowed destination = %d", rec.Code)
+	}
+}
+
+func TestCopySignatureCoversDestination(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "a")
+	secret := []byte("secret")
+	h := withHMACSignature(c, secret)
+	empty := sha256.Sum256(nil)
+	sum := hex.EncodeToString(empty[:])
+
+	sign := func(dst string) string {
+		mac := hmac.New(sha256.New, secret)
+		io.WriteString(mac, "COPY\n/a.txt\n\n"+sum+"\n"+dst)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	req := copyRequest("/a.txt", "/b.txt")
+	req.Header.Set(contentSHA256Header, sum)
+	req.Header.Set(signatureHeader, sign("/b.txt"))
+	if rec := serve(h, req); rec.Code != http.StatusCreated {
+		t.Fatalf("signed COPY = %d %s", rec.Code, rec.Body)
+	}
+
+	// A signature for another destination is rejected.
+	req = copyRequest("/a.txt", "/c.txt")
+	req.Header.Set(contentSHA256Header, sum)
+	req.Header.Set(signatureHeader, sign("/b.txt"))
+	if rec := serve(h, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("COPY with another destination = %d", rec.Code)
+	}
+}
+
+func benchmarkCopy(b *testing.B, threshold string) {
+	if testing.Short() {
+		b.Skip("copies 1 GB")
+	}
+	dir := b.TempDir()
+	setFlag(b, "data-dir", dir)
+	setFlag(b, "parallel-copy-threshold", threshold)
+	c := &restfs{dir: dir}
+
+	const size = 1 << 30
+	src := filepath.Join(dir, "src")
+	out, err := os.Create(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, err = io.CopyN(out, rand.Reader, size)
+	out.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	sum, err := hashFile(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	writeSidecar(src, checksumSuffix, &checksum{SHA256: sum, Size: size})
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.copyFile(filepath.Join(dir, "dst"), src, size); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopySerial(b *testing.B)   { benchmarkCopy(b, "2147483648") }
+func BenchmarkCopyParallel(b *testing.B) { benchmarkCopy(b, "1") }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const copyProgressInterval = 1000
+
+type copyFailure struct {
+	href string
+	code int
+}
+
+// serveCopyTree copies the directory tree in dirs to dst when the request
+// has Depth: infinity. Files that fail to copy are reported in a 207
+// Multi-Status response.
+func (c *restfs) serveCopyTree(w http.ResponseWriter, r *http.Request, dirs []string, dst string) {
+	if r.Header.Get("Depth") != "infinity" {
+		http.Error(w, "Cannot copy directory; forgot Depth: infinity?", http.StatusBadRequest)
+		return
+	}
+	src := path.Clean("/" + r.URL.Path)
+	dst = path.Clean("/" + dst)
+	if dst == src || strings.HasPrefix(dst, strings.TrimSuffix(src, "/")+"/") {
+		http.Error(w, "Cannot copy directory into itself", http.StatusBadRequest)
+		return
+	}
+	if s := stat(c.fullpath(dst)); s != nil && !s.IsDir() {
+		http.Error(w, "Cannot overwrite file with directory", http.StatusBadRequest)
+		return
+	}
+	exists := len(c.dirpaths(dst)) > 0
+	if exists && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+
+	start := time.Now()
+	var copied int
+	var failed []copyFailure
+	for _, dir := range dirs {
+		copyOne := func(name string) error {
+			s := stat(name)
+			if s == nil {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, name)
+			if err != nil {
+				return err
+			}
+			href := path.Join(dst, filepath.ToSlash(rel))
+			if err := c.copyTreeFile(c.fullpath(href), name, s.Size()); err != nil {
+				code, _ := classifyFSError(err)
+				failed = append(failed, copyFailure{href: href, code: code})
+				return nil
+			}
+			if copied++; copied%copyProgressInterval == 0 {
+				logJSON("copy_progress", map[string]interface{}{
+					"request_id": requestID(r),
+					"source":     src,
+					"dest":       dst,
+					"copied":     copied,
+					"failed":     len(failed),
+				})
+			}
+			return nil
+		}
+		// Uploads still in the write buffer are not in the walk, unless
+		// they are flushed meanwhile.
+		buffered := writeBuf.pendingOnly(dir)
+		skip := make(map[string]bool)
+		for _, name := range buffered {
+			skip[name] = true
+		}
+		err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() || isReserved(fi.Name()) || skip[name] {
+				return nil
+			}
+			return copyOne(name)
+		})
+		for _, name := range buffered {
+			if err != nil {
+				break
+			}
+			err = copyOne(name)
+		}
+		if err != nil {
+			code, msg := classifyFSError(err)
+			http.Error(w, msg, code)
+			return
+		}
+	}
+	recursiveCopyDuration.Observe(time.Since(start).Seconds())
+	recursiveCopyFiles.Observe(float64(copied))
+
+	if len(failed) > 0 {
+		writeMultiStatus(w, failed)
+	} else if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (c *restfs) copyTreeFile(dst, src string, size int64) error {
+	unlock := pathLocks.lock(dst)
+	defer unlock()
+	if !hasDiskSpace(c.dir, size) {
+		return syscall.ENOSPC
+	}
+	return c.copyEntry(dst, src, size)
+}
+
+func writeMultiStatus(w http.ResponseWriter, failed []copyFailure) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	for _, f := range failed {
+		buf.WriteString("<D:response><D:href>")
+		xml.EscapeText(&buf, []byte(f.href))
+		fmt.Fprintf(&buf, "</D:href><D:status>HTTP/1.1 %d %s</D:status></D:response>", f.code, http.StatusText(f.code))
+	}
+	buf.WriteString("</D:multistatus>\n")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func copyTreeRequest(src, dst string) *http.Request {
+	req := copyRequest(src, dst)
+	req.Header.Set("Depth", "infinity")
+	return req
+}
+
+func histogramSamples(t *testing.T, h prometheus.Histogram) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestCopyTree(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/src/a.txt", "a")
+	mustPut(t, c, "/src/sub/b.txt", "b")
+	mustPut(t, c, "/src/deleted.txt", "x")
+	do(c, "DELETE", "/src/deleted.txt", "")
+	n, files := histogramSamples(t, recursiveCopyFiles)
+	durations, _ := histogramSamples(t, recursiveCopyDuration)
+
+	if rec := serve(c, copyTreeRequest("/src/", "/dst/")); rec.Code != http.StatusCreated {
+		t.Fatalf("COPY = %d %s", rec.Code, rec.Body)
+	}
+	for p, want := range map[string]string{"/dst/a.txt": "a", "/dst/sub/b.txt": "b"} {
+		if rec := do(c, "GET", p, ""); rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("GET %s = %d %q", p, rec.Code, rec.Body)
+		}
+	}
+	if rec := do(c, "GET", "/dst/deleted.txt", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("tombstoned file copied: %d", rec.Code)
+	}
+	if got, sum := histogramSamples(t, recursiveCopyFiles); got != n+1 || sum != files+2 {
+		t.Errorf("recursive_copy_files = %d samples, sum %v", got-n, sum-files)
+	}
+	if got, _ := histogramSamples(t, recursiveCopyDuration); got != durations+1 {
+		t.Errorf("recursive_copy_duration_seconds = %d samples", got-durations)
+	}
+
+	if rec := serve(c, copyTreeRequest("/src/", "/dst/")); rec.Code != http.StatusNoContent {
+		t.Errorf("COPY over existing = %d", rec.Code)
+	}
+	req := copyTreeRequest("/src/", "/dst/")
+	req.Header.Set("Overwrite", "F")
+	if rec := serve(c, req); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("COPY with Overwrite: F = %d", rec.Code)
+	}
+	if rec := serve(c, copyRequest("/src/", "/other/")); rec.Code != http.StatusBadRequest {
+		t.Errorf("COPY without Depth = %d", rec.Code)
+	}
+	for _, dst := range []string{"/src/", "/src/sub/copy/"} {
+		if rec := serve(c, copyTreeRequest("/src/", dst)); rec.Code != http.StatusBadRequest {
+			t.Errorf("COPY into %s = %d", dst, rec.Code)
+		}
+	}
+	if rec := serve(c, copyTreeRequest("/src/", "/dst/a.txt")); rec.Code != http.StatusBadRequest {
+		t.Errorf("COPY over a file = %d", rec.Code)
+	}
+}
+
+func TestCopyTreeMultiStatus(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/src/a.txt", "a")
+	mustPut(t, c, "/src/sub/b.txt", "b")
+	setFlag(t, "reserve-bytes", "4611686018427387904")
+
+	rec := serve(c, copyTreeRequest("/src/", "/dst/"))
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("COPY = %d %s", rec.Code, rec.Body)
+	}
+	var ms struct {
+		Responses []struct {
+			Href   string `xml:"href"`
+			Status string `xml:"status"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(rec.Body).Decode(&ms); err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]string)
+	for _, r := range ms.Responses {
+		statuses[r.Href] = r.Status
+	}
+	want := "HTTP/1.1 507 Insufficient Storage"
+	if len(statuses) != 2 || statuses["/dst/a.txt"] != want || statuses["/dst/sub/b.txt"] != want {
+		t.Fatalf("responses = %v", statuses)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -9,7 +10,10 @@ import (
 	"github.com/rs/cors"
 )
 
-var corsOrigins = flag.String("cors-origins", "", "CORS origins (comma-separated)")
+var (
+	corsOrigins          = flag.String("cors-origins", "", "CORS origins (comma-separated)")
+	corsAllowCredentials = flag.Bool("cors-allow-credentials", false, "Allow cross-origin requests with cookies; required for cookie authentication from another site")
+)
 
 var corsHeaders []string
 
@@ -18,6 +22,35 @@ func addCORSHeaders(names ...string) {
 }
 
 func init() {
+	registerValidator(func() error {
+		if *corsOrigins == "" {
+			return nil
+		}
+		for _, origin := range strings.Split(*corsOrigins, ",") {
+			if origin == "" {
+				return fmt.Errorf("-cors-origins contains an empty origin: %q", *corsOrigins)
+			}
+			if origin == "*" && *corsAllowCredentials {
+				return fmt.Errorf("-cors-allow-credentials cannot be used with the wildcard origin")
+			}
+		}
+		return nil
+	})
+	registerInspector(10, "cors", func(r *http.Request, res *introspection) bool {
+		if *corsOrigins == "" {
+			return false
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			allowed := false
+			for _, o := range strings.Split(*corsOrigins, ",") {
+				if o == "*" || o == origin {
+					allowed = true
+				}
+			}
+			res.CORSAllowed = &allowed
+		}
+		return true
+	})
 	registerMiddleware(10, func(h http.Handler) http.Handler {
 		if *corsOrigins == "" {
 			return h
@@ -31,10 +64,11 @@ func init() {
 
 func CORS(h http.Handler, origins ...string) http.Handler {
 	c := cors.New(cors.Options{
-		AllowedOrigins: origins,
-		AllowedMethods: []string{"GET", "PUT", "DELETE"},
-		AllowedHeaders: corsHeaders,
-		MaxAge:         600,
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{"GET", "PUT", "DELETE", "COPY"},
+		AllowedHeaders:   corsHeaders,
+		AllowCredentials: *corsAllowCredentials,
+		MaxAge:           600,
 	})
 	return c.Handler(h)
 }
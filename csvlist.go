@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func serveCSVList(w http.ResponseWriter, r *http.Request, dirs []string) {
+	recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
+	var rows [][]string
+	seen := make(map[string]bool)
+	add := func(rel string, fi os.FileInfo, fullpath string) {
+		rel = filepath.ToSlash(rel)
+		if seen[rel] {
+			return
+		}
+		seen[rel] = true
+		var ctype string
+		if !fi.IsDir() {
+			ctype = contentType(fullpath)
+		}
+		rows = append(rows, []string{
+			rel,
+			strconv.FormatInt(fi.Size(), 10),
+			fi.ModTime().UTC().Format(time.RFC3339Nano),
+			ctype,
+			strconv.FormatBool(fi.IsDir()),
+		})
+	}
+
+	for _, dir := range dirs {
+		if err := csvListDir(dir, recursive, add); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(dirs) > 1 {
+		sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "size", "mtime", "content_type", "is_dir"})
+	cw.WriteAll(rows)
+}
+
+// csvListDir calls add for the live entries of dir, or of the whole tree
+// below it if recursive.
+func csvListDir(dir string, recursive bool, add func(rel string, fi os.FileInfo, fullpath string)) error {
+	return walkDir(dir, func(name string, fi os.FileInfo) error {
+		if isReserved(fi.Name()) || (!fi.IsDir() && (stat(name) == nil || fallbackDeleted(name))) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		add(rel, fi, name)
+		if !recursive && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// readCSVList returns the rows of a CSV listing by path.
+func readCSVList(t *testing.T, h http.Handler, p string) map[string][]string {
+	t.Helper()
+	rec := do(h, "GET", p, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", p, rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(records[0], ",") != "path,size,mtime,content_type,is_dir" {
+		t.Fatalf("header = %v", records[0])
+	}
+	rows := make(map[string][]string)
+	for _, rec := range records[1:] {
+		rows[rec[0]] = rec
+	}
+	return rows
+}
+
+func TestCSVList(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/dir/a.txt", "aaa")
+	mustPut(t, c, "/dir/with,comma.json", "{}")
+	mustPut(t, c, "/dir/with%22quote.txt", "q")
+	mustPut(t, c, "/dir/sub/b.txt", "bb")
+	mustPut(t, c, "/dir/deleted.txt", "x")
+	do(c, "DELETE", "/dir/deleted.txt", "")
+
+	rows := readCSVList(t, c, "/dir/?format=csv")
+	if len(rows) != 4 {
+		t.Fatalf("rows = %v", rows)
+	}
+	if r := rows["a.txt"]; r == nil || r[1] != "3" || r[3] != "text/plain; charset=utf-8" || r[4] != "false" {
+		t.Errorf("a.txt = %v", r)
+	}
+	if r := rows["with,comma.json"]; r == nil || r[1] != "2" || r[3] != "application/json" {
+		t.Errorf("with,comma.json = %v", r)
+	}
+	if rows[`with"quote.txt`] == nil {
+		t.Errorf("quoted name missing")
+	}
+	if r := rows["sub"]; r == nil || r[3] != "" || r[4] != "true" {
+		t.Errorf("sub = %v", r)
+	}
+	if rows["deleted.txt"] != nil {
+		t.Errorf("deleted file listed")
+	}
+
+	rows = readCSVList(t, c, "/dir/?format=csv&recursive=true")
+	for _, p := range []string{"a.txt", "with,comma.json", `with"quote.txt`, "sub", "sub/b.txt"} {
+		if rows[p] == nil {
+			t.Errorf("recursive listing misses %s", p)
+		}
+	}
+	if len(rows) != 5 {
+		t.Errorf("recursive rows = %v", rows)
+	}
+}
+
+func TestCSVListBufferedUpload(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/dir/a.txt", "a")
+	writeBuf.flush()
+	mustPut(t, c, "/dir/a.txt", "buffered")
+	mustPut(t, c, "/dir/sub/b.txt", "bb")
+
+	for _, q := range []string{"", "&recursive=true"} {
+		rows := readCSVList(t, c, "/dir/?format=csv"+q)
+		if r := rows["a.txt"]; r == nil || r[1] != "8" {
+			t.Errorf("%s: a.txt = %v", q, r)
+		}
+	}
+	rows := readCSVList(t, c, "/dir/?format=csv&recursive=true")
+	if r := rows["sub/b.txt"]; r == nil || r[1] != "2" {
+		t.Errorf("sub/b.txt = %v", r)
+	}
+}
+
+func TestCSVListFallback(t *testing.T) {
+	c := newFallbackFS(t, map[string]string{"a.txt": "fallback", "dir/gone.txt": "x", "dir/kept.txt": "y"})
+	mustPut(t, c, "/a.txt", "primary")
+	do(c, "DELETE", "/dir/gone.txt", "")
+
+	rows := readCSVList(t, c, "/?format=csv&recursive=true")
+	if r := rows["a.txt"]; r == nil || r[1] != "7" {
+		t.Errorf("a.txt = %v", r)
+	}
+	if rows["dir/kept.txt"] == nil {
+		t.Errorf("fallback file missing: %v", rows)
+	}
+	if rows["dir/gone.txt"] != nil {
+		t.Errorf("deleted fallback file listed")
+	}
+}
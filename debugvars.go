@@ -0,0 +1,48 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var debugMode = flag.Bool("debug", false, "Enable debug endpoints")
+
+var tombstoneCount = expvar.NewInt("restfs.tombstone_count")
+
+func init() {
+	startupTime := time.Now().Format(time.RFC3339)
+	expvar.Publish("restfs.data_dir", expvar.Func(func() interface{} { return dataDirPath() }))
+	expvar.Publish("restfs.startup_time", expvar.Func(func() interface{} { return startupTime }))
+	registerEndpoint("/-/debug/vars", serveDebugVars)
+}
+
+func serveDebugVars(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if !*debugMode {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !adminAllowed(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	// The standard cmdline variable is left out since it would reveal
+	// secrets passed as flags.
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprint(w, ",")
+		}
+		first = false
+		fmt.Fprintf(w, "\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
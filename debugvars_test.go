@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDebugVars(t *testing.T) {
+	c := newTestFS(t)
+	if rec := do(c, "GET", "/-/debug/vars", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("status without -debug = %d", rec.Code)
+	}
+
+	setFlag(t, "debug", "true")
+	rec := do(c, "GET", "/-/debug/vars", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var vars map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"restfs.data_dir", "restfs.tombstone_count", "restfs.startup_time", "memstats"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	if vars["restfs.data_dir"] != c.dir {
+		t.Errorf("restfs.data_dir = %v", vars["restfs.data_dir"])
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("cmdline is exposed")
+	}
+}
+
+func TestDebugVarsAdminAllow(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "debug", "true")
+	setFlag(t, "admin-allow", "10.0.0.0/8")
+
+	r := newRequest("GET", "/-/debug/vars", "")
+	r.RemoteAddr = "192.0.2.1:1234"
+	if rec := serve(c, r); rec.Code != http.StatusForbidden {
+		t.Fatalf("status from outside the allowlist = %d", rec.Code)
+	}
+	r.RemoteAddr = "10.1.1.1:1234"
+	if rec := serve(c, r); rec.Code != http.StatusOK {
+		t.Fatalf("status from the allowlist = %d", rec.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type change struct {
+	Path    string    `json:"path"`
+	Op      string    `json:"op"`
+	Mtime   time.Time `json:"mtime"`
+	Size    int64     `json:"size"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// serveDiff lists changes under dirs since the time given by the diff
+// parameter. restfs keeps no history of creations, so new and modified files
+// are both reported as updates.
+func serveDiff(w http.ResponseWriter, r *http.Request, dirs []string) {
+	since, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("diff"))
+	if err != nil {
+		http.Error(w, "Invalid diff parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := []change{}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if result, err = diffDir(result, seen, dir, since); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(dirs) > 1 {
+		sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"changes": result})
+}
+
+// diffDir appends the changes below dir to result. Paths in seen were
+// reported from a directory listed earlier, which takes precedence.
+func diffDir(result []change, seen map[string]bool, dir string, since time.Time) ([]change, error) {
+	err := walkDir(dir, func(name string, fi os.FileInfo) error {
+		if fi.IsDir() || !fi.ModTime().After(since) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if target, ok := tombstoneTarget(rel); ok {
+			fname, _ := tombstoneTarget(name)
+			if seen[target] || stat(fname) != nil {
+				return nil
+			}
+			seen[target] = true
+			result = append(result, change{
+				Path:    target,
+				Op:      "delete",
+				Mtime:   fi.ModTime(),
+				Deleted: true,
+			})
+			return nil
+		}
+		if seen[rel] || isReserved(fi.Name()) || stat(name) == nil || fallbackDeleted(name) {
+			return nil
+		}
+		seen[rel] = true
+		result = append(result, change{
+			Path:  rel,
+			Op:    "update",
+			Mtime: fi.ModTime(),
+			Size:  fi.Size(),
+		})
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// getDiff returns the changes under p since the given time as op:path.
+func getDiff(t *testing.T, h http.Handler, p string, since time.Time) []string {
+	t.Helper()
+	rec := do(h, "GET", p+"?diff="+url.QueryEscape(since.Format(time.RFC3339Nano)), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("diff: %d %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		Changes []change `json:"changes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	var changes []string
+	for _, ch := range res.Changes {
+		if ch.Deleted != (ch.Op == "delete") {
+			t.Errorf("%s: op %s, deleted %v", ch.Path, ch.Op, ch.Deleted)
+		}
+		changes = append(changes, ch.Op+":"+ch.Path)
+	}
+	return changes
+}
+
+func TestDiff(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/old.txt", "old")
+	mustPut(t, c, "/a.txt", "a")
+	mustPut(t, c, "/dir/b.txt", "b")
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	mustPut(t, c, "/a.txt", "changed")
+	mustPut(t, c, "/dir/c.txt", "new")
+	do(c, "DELETE", "/dir/b.txt", "")
+	want := []string{"update:a.txt", "delete:dir/b.txt", "update:dir/c.txt"}
+	if got := getDiff(t, c, "/", since); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+
+	// A file written again after its deletion is an update.
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, c, "/dir/b.txt", "again")
+	want = []string{"update:b.txt", "update:c.txt"}
+	if got := getDiff(t, c, "/dir/", since); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+
+	if rec := do(c, "GET", "/?diff=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid diff parameter: %d", rec.Code)
+	}
+}
+
+func TestDiffBufferedUpload(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/dir/a.txt", "a")
+	writeBuf.flush()
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	mustPut(t, c, "/dir/a.txt", "buffered")
+	mustPut(t, c, "/dir/b.txt", "buffered")
+	want := []string{"update:a.txt", "update:b.txt"}
+	if got := getDiff(t, c, "/dir/", since); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+}
+
+func TestDiffFallback(t *testing.T) {
+	since := time.Now().Add(-time.Second)
+	c := newFallbackFS(t, map[string]string{"gone.txt": "x", "kept.txt": "y"})
+	do(c, "DELETE", "/gone.txt", "")
+	want := []string{"delete:gone.txt", "update:kept.txt"}
+	if got := getDiff(t, c, "/", since); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+}
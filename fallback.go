@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var fallbackDir = flag.String("fallback-dir", "", "Serve files missing from the data directory from this read-only directory")
+
+// fallbackPrimary is the file system whose tombstones shadow files in the
+// fallback directory.
+var fallbackPrimary *restfs
+
+func init() {
+	registerValidator(func() error {
+		if *fallbackDir == "" {
+			return nil
+		}
+		fi, err := os.Stat(*fallbackDir)
+		if err != nil {
+			return fmt.Errorf("invalid -fallback-dir: %v", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("fallback directory %s is not a directory", *fallbackDir)
+		}
+		fb, err := filepath.Abs(*fallbackDir)
+		if err != nil {
+			return err
+		}
+		data, err := filepath.Abs(dataDirPath())
+		if err != nil {
+			return err
+		}
+		if fb == data || strings.HasPrefix(fb, data+string(filepath.Separator)) || strings.HasPrefix(data, fb+string(filepath.Separator)) {
+			return errors.New("-fallback-dir must not overlap the data directory")
+		}
+		return nil
+	})
+}
+
+// fallbackFile returns the fallback copy of the URL path p, or "" if there
+// is none or the file was deleted in the primary.
+func (c *restfs) fallbackFile(p, fullpath string) string {
+	if *fallbackDir == "" {
+		return ""
+	}
+	if _, _, err := findTombstone(fullpath); err == nil {
+		return ""
+	}
+	fb := path.Join(*fallbackDir, path.Clean("/"+p))
+	if s := stat(fb); s == nil || s.IsDir() {
+		return ""
+	}
+	return fb
+}
+
+// readablePath returns the file to read for the URL path p: its primary
+// copy unless only the fallback directory has it.
+func (c *restfs) readablePath(p string) string {
+	fullpath := c.fullpath(p)
+	if stat(fullpath) == nil {
+		if fb := c.fallbackFile(p, fullpath); fb != "" {
+			return fb
+		}
+	}
+	return fullpath
+}
+
+// fallbackDirs appends the fallback directory for the URL path p to dirs.
+// It comes last so that primary entries take precedence in listings.
+func (c *restfs) fallbackDirs(p string, dirs []string) []string {
+	if *fallbackDir == "" {
+		return dirs
+	}
+	fb := path.Join(*fallbackDir, path.Clean("/"+p))
+	if fi, err := os.Stat(fb); err == nil && fi.IsDir() {
+		dirs = append(dirs, fb)
+	}
+	return dirs
+}
+
+// filterFallback drops entries of a fallback directory that were deleted in
+// the primary.
+func filterFallback(dir string, names []string) []string {
+	live := names[:0]
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") || !fallbackDeleted(path.Join(dir, strings.TrimSuffix(name, "@"))) {
+			live = append(live, name)
+		}
+	}
+	return live
+}
+
+// fallbackDeleted reports whether name is a file in the fallback directory
+// that was deleted in the primary.
+func fallbackDeleted(name string) bool {
+	root := path.Clean(*fallbackDir)
+	if *fallbackDir == "" || !strings.HasPrefix(name, root+"/") {
+		return false
+	}
+	_, _, err := findTombstone(fallbackPrimary.fullpath(strings.TrimPrefix(name, root)))
+	return err == nil
+}
+
+// shadowsFallback reports whether the tombstone of the primary file fname
+// must be kept because it hides a file in the fallback directory.
+func shadowsFallback(fname string) bool {
+	if *fallbackDir == "" || fallbackPrimary == nil {
+		return false
+	}
+	url, err := fallbackPrimary.urlpath(fname)
+	if err != nil {
+		return false
+	}
+	s := stat(path.Join(*fallbackDir, url))
+	return s != nil && !s.IsDir()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFallbackFS returns a restfs whose fallback directory holds files.
+func newFallbackFS(t *testing.T, files map[string]string) *restfs {
+	t.Helper()
+	c := newTestFS(t)
+	fb := t.TempDir()
+	for name, body := range files {
+		p := filepath.Join(fb, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(body), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setFlag(t, "fallback-dir", fb)
+	fallbackPrimary = c
+	t.Cleanup(func() { fallbackPrimary = nil })
+	return c
+}
+
+// readBundle returns the contents of a tar.gz bundle by entry name.
+func readBundle(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestFallbackGet(t *testing.T) {
+	c := newFallbackFS(t, map[string]string{"a.txt": "fallback", "b.txt": "old"})
+	mustPut(t, c, "/b.txt", "new")
+
+	for p, want := range map[string]string{"/a.txt": "fallback", "/b.txt": "new"} {
+		if rec := do(c, "GET", p, ""); rec.Code != 200 || rec.Body.String() != want {
+			t.Errorf("GET %s: %d %q, want %q", p, rec.Code, rec.Body, want)
+		}
+	}
+	if rec := do(c, "DELETE", "/a.txt", ""); rec.Code >= 300 {
+		t.Fatalf("DELETE: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Code != 404 {
+		t.Errorf("GET deleted fallback file: %d", rec.Code)
+	}
+}
+
+func TestFallbackNotCounted(t *testing.T) {
+	c := newFallbackFS(t, map[string]string{"a.txt": "fallback"})
+	resetAccessCounts(t)
+
+	if rec := do(c, "GET", "/a.txt", ""); rec.Code != 200 {
+		t.Fatalf("GET: %d", rec.Code)
+	}
+	flushAccessCounts()
+	err := filepath.Walk(*fallbackDir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(p, accessCountSuffix) {
+			t.Errorf("access count written to fallback directory: %s", p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFallbackBatchGet(t *testing.T) {
+	c := newFallbackFS(t, map[string]string{"a.txt": "fallback", "dir/b.txt": "bb"})
+	mustPut(t, c, "/c.txt", "primary")
+
+	rec := do(c, "POST", "/-/batch-get", `{"paths":["/a.txt","/dir/b.txt","/c.txt","/missing"]}`)
+	codes, bodies := readBatch(t, rec.Result())
+	if strings.Join(codes, ",") != "200,200,200,404" {
+		t.Fatalf("codes = %v", codes)
+	}
+	if bodies[0] != "fallback" || bodies[1] != "bb" || bodies[2] != "primary" {
+		t.Fatalf("bodies = %q", bodies)
+	}
+}
+
+func TestFallbackBundle(t *testing.T) {
+	c := newFallbackFS(t, map[string]string{"a.txt": "fallback"})
+	setFlag(t, "presign-secret", "secret")
+	mustPut(t, c, "/c.txt", "primary")
+
+	paths := bundlePaths([]string{"/a.txt", "/c.txt"})
+	expires := time.Now().Add(time.Minute).Unix()
+	q := url.Values{"path": paths}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("bundle-sig", signBundle(paths, expires))
+	rec := do(c, "GET", bundlePath+"?"+q.Encode(), "")
+	if rec.Code != 200 {
+		t.Fatalf("bundle: %d %s", rec.Code, rec.Body)
+	}
+	files := readBundle(t, rec.Body)
+	if len(files) != 2 || files["a.txt"] != "fallback" || files["c.txt"] != "primary" {
+		t.Fatalf("bundle = %q", files)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/list"
+	"flag"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var (
+	fileCacheSize    = flag.Int64("file-cache-size", 0, "Keep contents of small files in memory up to this many bytes in total; 0 disables the cache")
+	fileCacheMaxFile = flag.Int64("file-cache-max-file", 1<<20, "Largest file kept in the file cache")
+)
+
+type fileCacheEntry struct {
+	key  string
+	stat os.FileInfo
+	data []byte
+}
+
+// fileCache is an LRU cache of file contents bounded by their total size.
+// Entries are validated against the inode, size and mtime of the file. Writes
+// replace the file with a new one, so they need not invalidate them.
+type fileCache struct {
+	mu    sync.Mutex
+	used  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+var fileContents = newFileCache()
+
+func newFileCache() *fileCache {
+	return &fileCache{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *fileCache) get(key string, s os.FileInfo) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*fileCacheEntry)
+	if !sameFile(entry.stat, s) {
+		c.remove(e)
+		return nil
+	}
+	c.ll.MoveToFront(e)
+	return entry.data
+}
+
+func (c *fileCache) add(key string, s os.FileInfo, data []byte, limit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	c.items[key] = c.ll.PushFront(&fileCacheEntry{key: key, stat: s, data: data})
+	c.used += int64(len(data))
+	for c.used > limit {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *fileCache) remove(e *list.Element) {
+	entry := e.Value.(*fileCacheEntry)
+	c.ll.Remove(e)
+	delete(c.items, entry.key)
+	c.used -= int64(len(entry.data))
+}
+
+// cachedContent returns the contents of the file at fullpath, which has the
+// stat s, reading them into the cache on a miss. It returns nil when the
+// cache is disabled or the file is too large.
+func cachedContent(fullpath string, s os.FileInfo) []byte {
+	if *fileCacheSize <= 0 || s.Size() > *fileCacheMaxFile || s.Size() > *fileCacheSize {
+		return nil
+	}
+	if data := fileContents.get(fullpath, s); data != nil {
+		return data
+	}
+	f, err := os.Open(writeBuf.locate(fullpath))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	// The file may have been replaced since s was taken.
+	if fs, err := f.Stat(); err != nil || !sameFile(fs, s) {
+		return nil
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil || int64(len(data)) != s.Size() {
+		return nil
+	}
+	fileContents.add(fullpath, s, data, *fileCacheSize)
+	return data
+}
+
+// sameFile reports whether a and b describe the same unchanged file. The
+// mtime alone may be too coarse to tell writes apart.
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
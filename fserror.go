@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+)
+
+// classifyFSError maps filesystem errors to an HTTP status code and a
+// message suitable for clients.
+func classifyFSError(err error) (int, string) {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return http.StatusInsufficientStorage, "server disk full"
+	case errors.Is(err, syscall.EDQUOT):
+		return http.StatusInsufficientStorage, "disk quota exceeded for user"
+	case errors.Is(err, syscall.EROFS):
+		return http.StatusInternalServerError, "filesystem is read-only (bug: should have been caught at startup)"
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return http.StatusServiceUnavailable, "server ran out of file descriptors"
+	}
+	return http.StatusInternalServerError, err.Error()
+}
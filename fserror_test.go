@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyFSError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+		msg  string
+	}{
+		{syscall.ENOSPC, http.StatusInsufficientStorage, "server disk full"},
+		{syscall.EDQUOT, http.StatusInsufficientStorage, "disk quota exceeded for user"},
+		{syscall.EROFS, http.StatusInternalServerError, "filesystem is read-only (bug: should have been caught at startup)"},
+		{syscall.EMFILE, http.StatusServiceUnavailable, "server ran out of file descriptors"},
+		{syscall.ENFILE, http.StatusServiceUnavailable, "server ran out of file descriptors"},
+		{&os.PathError{Op: "write", Path: "/data/a", Err: syscall.ENOSPC}, http.StatusInsufficientStorage, "server disk full"},
+		{fmt.Errorf("copy: %w", &os.PathError{Op: "open", Path: "/data/a", Err: syscall.EDQUOT}), http.StatusInsufficientStorage, "disk quota exceeded for user"},
+		{errors.New("other"), http.StatusInternalServerError, "other"},
+	}
+	for _, tt := range tests {
+		code, msg := classifyFSError(tt.err)
+		if code != tt.code || msg != tt.msg {
+			t.Errorf("%v: %d %q, want %d %q", tt.err, code, msg, tt.code, tt.msg)
+		}
+	}
+}
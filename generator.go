@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+)
+
+type generator struct {
+	pattern string
+	gen     func(ctx context.Context, p string, w io.Writer) error
+}
+
+var generators []generator
+
+// registerGenerator serves GET requests for paths matching pattern with gen
+// when nothing exists at the path on disk. Files on disk always take
+// precedence over generated ones.
+func registerGenerator(pattern string, gen func(ctx context.Context, p string, w io.Writer) error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(err)
+	}
+	generators = append(generators, generator{pattern: pattern, gen: gen})
+}
+
+func findGenerator(p string) *generator {
+	for i := range generators {
+		if ok, _ := path.Match(generators[i].pattern, p); ok {
+			return &generators[i]
+		}
+	}
+	return nil
+}
+
+func serveGenerated(w http.ResponseWriter, r *http.Request, g *generator) {
+	p := path.Clean("/" + r.URL.Path)
+	if ctype := mime.TypeByExtension(path.Ext(p)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	if err := g.gen(r.Context(), p, cw); err != nil {
+		log.Printf("Generator for %s failed: %v", p, err)
+		if cw.n == 0 {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func withGenerator(t *testing.T, pattern string, gen func(ctx context.Context, p string, w io.Writer) error) {
+	old := generators
+	registerGenerator(pattern, gen)
+	t.Cleanup(func() { generators = old })
+}
+
+func TestGenerator(t *testing.T) {
+	c := newTestFS(t)
+	var calls int
+	withGenerator(t, "/status/*.txt", func(ctx context.Context, p string, w io.Writer) error {
+		calls++
+		_, err := fmt.Fprintf(w, "%s %s", p, time.Now().UTC().Format(time.RFC3339Nano))
+		return err
+	})
+
+	rec := do(c, "GET", "/status/now.txt", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("generated: %d %v", rec.Code, rec.Header())
+	}
+	var p, stamp string
+	fmt.Sscan(rec.Body.String(), &p, &stamp)
+	ts, err := time.Parse(time.RFC3339Nano, stamp)
+	if p != "/status/now.txt" || err != nil || time.Since(ts) > time.Minute {
+		t.Fatalf("body = %q", rec.Body)
+	}
+
+	// Files on disk take precedence.
+	mustPut(t, c, "/status/now.txt", "stored")
+	if rec := do(c, "GET", "/status/now.txt", ""); rec.Body.String() != "stored" {
+		t.Fatalf("disk file shadowed by the generator: %q", rec.Body)
+	}
+	if calls != 1 {
+		t.Fatalf("generator called %d times, want 1", calls)
+	}
+	if rec := do(c, "GET", "/status/other.json", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unmatched path: %d", rec.Code)
+	}
+}
+
+func TestGeneratorError(t *testing.T) {
+	c := newTestFS(t)
+	captureLog(t)
+	withGenerator(t, "/broken", func(ctx context.Context, p string, w io.Writer) error {
+		return errors.New("boom")
+	})
+	withGenerator(t, "/partial", func(ctx context.Context, p string, w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("boom")
+	})
+	if rec := do(c, "GET", "/broken", ""); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failing generator: %d", rec.Code)
+	}
+	if rec := do(c, "GET", "/partial", ""); rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Fatalf("generator failing after output: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestGeneratorContext(t *testing.T) {
+	c := newTestFS(t)
+	withGenerator(t, "/ctx", func(ctx context.Context, p string, w io.Writer) error {
+		if ctx.Value(ctxKey{}) != "request" {
+			return errors.New("not the request context")
+		}
+		return nil
+	})
+	r := newRequest("GET", "/ctx", "")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, "request"))
+	if rec := serve(c, r); rec.Code != http.StatusOK {
+		t.Fatalf("generator got another context: %d", rec.Code)
+	}
+}
+
+type ctxKey struct{}
+
+func TestRegisterGeneratorInvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("invalid pattern accepted")
+		}
+	}()
+	withGenerator(t, "[", nil)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var transparentGunzip = flag.Bool("transparent-gunzip", false, "Decompress stored .gz files for clients not accepting gzip")
+
+func shouldGunzip(r *http.Request, fullpath string) bool {
+	return *transparentGunzip && strings.HasSuffix(fullpath, ".gz") && !acceptsGzip(r)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, item := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, q := parseQuality(item)
+		if q > 0 && (coding == "gzip" || coding == "x-gzip" || coding == "*") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseQuality(item string) (string, float64) {
+	parts := strings.Split(item, ";")
+	value := strings.ToLower(strings.TrimSpace(parts[0]))
+	q := 1.0
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return value, q
+}
+
+func serveGunzipped(w http.ResponseWriter, fullpath string) {
+	f, err := os.Open(fullpath)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		http.Error(w, "Cannot decompress file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer zr.Close()
+
+	ctype := mime.TypeByExtension(path.Ext(strings.TrimSuffix(fullpath, ".gz")))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Encoding", "identity")
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, zr); err != nil {
+		log.Printf("Failed to decompress %s: %v", fullpath, err)
+	}
+}
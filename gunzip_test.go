@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestTransparentGunzip(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "transparent-gunzip", "true")
+	body := `{"a":1}`
+	compressed := gzipped(t, body)
+	mustPut(t, c, "/data/file.json.gz", compressed)
+
+	tests := []struct {
+		acceptEncoding string
+		gunzip         bool
+	}{
+		{"", true},
+		{"identity", true},
+		{"gzip", false},
+		{"deflate, gzip;q=0.5", false},
+		{"x-gzip", false},
+		{"*", false},
+		{"gzip;q=0", true},
+		{"br, deflate", true},
+	}
+	for _, tt := range tests {
+		r := newRequest("GET", "/data/file.json.gz", "")
+		if tt.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		rec := serve(c, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept-Encoding %q: %d", tt.acceptEncoding, rec.Code)
+		}
+		if !tt.gunzip {
+			if rec.Body.String() != compressed {
+				t.Errorf("Accept-Encoding %q: body was decompressed", tt.acceptEncoding)
+			}
+			continue
+		}
+		if rec.Body.String() != body {
+			t.Errorf("Accept-Encoding %q: body = %q", tt.acceptEncoding, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Accept-Encoding %q: Content-Type = %q", tt.acceptEncoding, ct)
+		}
+		if ce := rec.Header().Get("Content-Encoding"); ce != "identity" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q", tt.acceptEncoding, ce)
+		}
+		if cl := rec.Header().Get("Content-Length"); cl != "" {
+			t.Errorf("Accept-Encoding %q: Content-Length = %q", tt.acceptEncoding, cl)
+		}
+	}
+}
+
+func TestTransparentGunzipDisabled(t *testing.T) {
+	c := newTestFS(t)
+	compressed := gzipped(t, "text")
+	mustPut(t, c, "/file.txt.gz", compressed)
+	if rec := do(c, "GET", "/file.txt.gz", ""); rec.Body.String() != compressed {
+		t.Fatalf("body was decompressed without -transparent-gunzip")
+	}
+}
+
+func TestTransparentGunzipInvalid(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "transparent-gunzip", "true")
+	mustPut(t, c, "/broken.gz", "not gzip")
+	if rec := do(c, "GET", "/broken.gz", ""); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("invalid gzip: %d", rec.Code)
+	}
+}
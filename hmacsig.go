@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var hmacSecret = flag.String("hmac-secret", "", "Require modifying requests to be signed with HMAC-SHA256 using this secret")
+
+const (
+	signatureHeader     = "X-Restfs-Signature"
+	contentSHA256Header = "X-Content-SHA256"
+)
+
+var errBodyMismatch = errors.New("body does not match its declared hash")
+
+func init() {
+	addCORSHeaders(signatureHeader, contentSHA256Header)
+	registerInspector(21, "hmac", func(r *http.Request, res *introspection) bool {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			return false
+		}
+		if *hmacSecret == "" {
+			return false
+		}
+		res.WouldAuthenticate = true
+		if res.AuthMethod == "" {
+			res.AuthMethod = "hmac"
+		}
+		return true
+	})
+	registerMiddleware(21, func(h http.Handler) http.Handler {
+		if *hmacSecret == "" {
+			return h
+		}
+
+		log.Print("HMAC request signatures required")
+		return withHMACSignature(h, []byte(*hmacSecret))
+	})
+}
+
+// withHMACSignature rejects modifying requests unless X-Restfs-Signature
+// carries HMAC-SHA256(secret, method+"\n"+path+"\n"+query+"\n"+content-sha256)
+// and the body matches the declared content hash. The query is the raw query
+// string as sent, without the "?", and empty if there is none. Requests with a
+// Destination header, such as COPY, sign "\n"+destination in addition.
+func withHMACSignature(h http.Handler, secret []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			h.ServeHTTP(w, r)
+			return
+		}
+		sig := r.Header.Get(signatureHeader)
+		if !strings.HasPrefix(sig, "sha256=") {
+			http.Error(w, "Missing request signature", http.StatusUnauthorized)
+			return
+		}
+		sum := strings.ToLower(r.Header.Get(contentSHA256Header))
+		if sum == "" {
+			http.Error(w, "Missing "+contentSHA256Header+" header", http.StatusBadRequest)
+			return
+		}
+		want, err := hex.DecodeString(sig[len("sha256="):])
+		if err != nil || !hmac.Equal(want, signRequest(secret, r.Method, r.URL.Path, r.URL.RawQuery, sum, r.Header.Get("Destination"))) {
+			http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := bufferBody(r.Body, sum)
+		if err == errBodyMismatch {
+			http.Error(w, "Body does not match "+contentSHA256Header, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Print(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+		r.Body = body
+		h.ServeHTTP(w, r)
+	})
+}
+
+func signRequest(secret []byte, method, path, query, sum, dest string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+path+"\n"+query+"\n"+sum)
+	if dest != "" {
+		io.WriteString(mac, "\n"+dest)
+	}
+	return mac.Sum(nil)
+}
+
+// bufferBody copies body to a temporary file so that its hash can be checked
+// before anything is stored. The returned file is removed on Close.
+func bufferBody(body io.ReadCloser, sum string) (io.ReadCloser, error) {
+	defer body.Close()
+	f, err := ioutil.TempFile("", "restfs-body-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), body)
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != sum {
+		err = errBodyMismatch
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
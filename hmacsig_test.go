@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+// signedRequest signs a request for target with secret the way clients do.
+func signedRequest(secret, method, target, body string) *http.Request {
+	r := newRequest(method, target, body)
+	sum := sha256.Sum256([]byte(body))
+	r.Header.Set(contentSHA256Header, hex.EncodeToString(sum[:]))
+	sig := signRequest([]byte(secret), method, r.URL.Path, r.URL.RawQuery, hex.EncodeToString(sum[:]), "")
+	r.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(sig))
+	return r
+}
+
+func TestHMACSignature(t *testing.T) {
+	c := newTestFS(t)
+	h := withHMACSignature(c, []byte("secret"))
+
+	if rec := serve(h, newRequest("PUT", "/a.txt", "data")); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned PUT: %d", rec.Code)
+	}
+	if rec := serve(h, signedRequest("other", "PUT", "/a.txt", "data")); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("PUT signed with another secret: %d", rec.Code)
+	}
+	if rec := serve(h, signedRequest("secret", "PUT", "/a.txt", "data")); rec.Code != http.StatusOK {
+		t.Fatalf("signed PUT: %d %s", rec.Code, rec.Body)
+	}
+	r := signedRequest("secret", "PUT", "/a.txt", "data")
+	r.Body = newRequest("PUT", "/", "tampered").Body
+	if rec := serve(h, r); rec.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with a tampered body: %d", rec.Code)
+	}
+	if rec := serve(h, newRequest("GET", "/a.txt", "")); rec.Code != http.StatusOK || rec.Body.String() != "data" {
+		t.Fatalf("GET: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestHMACSignatureQuery(t *testing.T) {
+	c := newTestFS(t)
+	h := withHMACSignature(c, []byte("secret"))
+	mustPut(t, c, "/dir/a.txt", "data")
+
+	// A signature for DELETE /dir does not cover ?recursive=true.
+	r := signedRequest("secret", "DELETE", "/dir", "")
+	r.URL.RawQuery = "recursive=true"
+	if rec := serve(h, r); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("DELETE with an unsigned query: %d", rec.Code)
+	}
+	if rec := serve(h, signedRequest("secret", "DELETE", "/dir?recursive=true", "")); rec.Code != http.StatusOK {
+		t.Fatalf("signed recursive DELETE: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(c, "GET", "/dir/a.txt", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET after recursive DELETE: %d", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var idleShutdownAfter = flag.Duration("idle-shutdown-after", 0, "Shut down after this long without requests (0 to disable)")
+
+var lastActivity = time.Now().UnixNano()
+
+func init() {
+	registerInspector(0, "idle", func(r *http.Request, res *introspection) bool {
+		return *idleShutdownAfter > 0
+	})
+	registerMiddleware(0, func(h http.Handler) http.Handler {
+		if *idleShutdownAfter <= 0 {
+			return h
+		}
+
+		log.Printf("Shutting down after %s of inactivity", *idleShutdownAfter)
+		return withIdleShutdown(h, *idleShutdownAfter)
+	})
+}
+
+func withIdleShutdown(h http.Handler, limit time.Duration) http.Handler {
+	atomic.StoreInt64(&lastActivity, time.Now().UnixNano())
+	go watchIdle(limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt64(&lastActivity, time.Now().UnixNano())
+		h.ServeHTTP(w, r)
+	})
+}
+
+func watchIdle(limit time.Duration) {
+	interval := time.Minute
+	if limit < interval {
+		interval = limit
+	}
+	for range time.Tick(interval) {
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&lastActivity)))
+		if idle >= limit {
+			log.Printf("No requests for %s; shutting down", idle)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			return
+		}
+	}
+}
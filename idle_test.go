@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIdleShutdown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the idle timeout")
+	}
+	captureLog(t)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	c := newTestFS(t)
+	h := withIdleShutdown(c, 2*time.Second)
+	// Requests keep the server alive.
+	for i := 0; i < 3; i++ {
+		do(h, "GET", "/-/ready", "")
+		select {
+		case <-sig:
+			t.Fatal("shut down while serving requests")
+		case <-time.After(time.Second):
+		}
+	}
+	last := time.Now()
+	do(h, "GET", "/", "")
+	select {
+	case <-sig:
+		if idle := time.Since(last); idle < 2*time.Second {
+			t.Fatalf("shut down after %s of inactivity", idle)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no shutdown after the idle timeout")
+	}
+}
+
+func TestIdleShutdownPassesThrough(t *testing.T) {
+	h := withIdleShutdown(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), time.Hour)
+	if rec := do(h, "GET", "/", ""); rec.Code != http.StatusTeapot {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
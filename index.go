@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var precomputeIndexInterval = flag.Duration("precompute-index-interval", 0, "Precompute listings of large directories at this interval; 0 disables")
+
+const (
+	// indexFile is stored inside the directory it lists. Being reserved, it
+	// is not part of the listing itself.
+	indexFile = ".restfs-index.json"
+
+	// Listing small directories is cheap enough that an index would not pay
+	// for the extra file.
+	indexMinEntries = 1000
+)
+
+type dirIndex struct {
+	DirMtime time.Time `json:"dir_mtime"`
+	Names    []string  `json:"names"`
+}
+
+var indexRebuild = make(chan string, 64)
+
+func init() {
+	sidecarSuffixes = append(sidecarSuffixes, indexFile)
+}
+
+// startIndexer keeps the index of every large directory below root up to
+// date.
+func startIndexer(root string) {
+	if *precomputeIndexInterval <= 0 {
+		return
+	}
+	log.Printf("Directory indexes are rebuilt every %s", *precomputeIndexInterval)
+	go func() {
+		tick := time.Tick(*precomputeIndexInterval)
+		for {
+			select {
+			case <-tick:
+				err := filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+					if err != nil || !fi.IsDir() {
+						return err
+					}
+					return buildIndex(name)
+				})
+				if err != nil {
+					log.Printf("Failed to build directory indexes: %v", err)
+				}
+			case dir := <-indexRebuild:
+				if err := buildIndex(dir); err != nil {
+					log.Printf("Failed to build index of %s: %v", dir, err)
+				}
+			}
+		}
+	}()
+}
+
+// requestIndexRebuild queues a rebuild of the index of dir after a write.
+func requestIndexRebuild(dir string) {
+	if *precomputeIndexInterval <= 0 {
+		return
+	}
+	select {
+	case indexRebuild <- dir:
+	default:
+	}
+}
+
+// readIndex returns the precomputed listing of dir unless the directory has
+// changed since it was built.
+func readIndex(dir string) ([]string, bool) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(path.Join(dir, indexFile))
+	if err != nil {
+		return nil, false
+	}
+	var idx dirIndex
+	if err := json.Unmarshal(b, &idx); err != nil || !idx.DirMtime.Equal(fi.ModTime()) {
+		return nil, false
+	}
+	return idx.Names, true
+}
+
+func buildIndex(dir string) error {
+	if _, ok := readIndex(dir); ok {
+		return nil
+	}
+	name := path.Join(dir, indexFile)
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		names, err := listDir(dir)
+		if err != nil || len(names) < indexMinEntries {
+			return err
+		}
+		// Adding the index to dir changes its mtime, so the index has to
+		// exist before the mtime is taken. It is only ever rewritten in place
+		// afterwards.
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	} else if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	names, err := listDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(names) < indexMinEntries {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(&dirIndex{DirMtime: fi.ModTime(), Names: names})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0666)
+}
+
+// indexedListDir is listDir served from the index when it is fresh. A stale
+// index is rebuilt in the background.
+func indexedListDir(dir string) ([]string, error) {
+	if *precomputeIndexInterval <= 0 {
+		return listDir(dir)
+	}
+	if names, ok := readIndex(dir); ok {
+		return names, nil
+	}
+	names, err := listDir(dir)
+	if err == nil && len(names) >= indexMinEntries {
+		requestIndexRebuild(dir)
+	}
+	return names, err
+}
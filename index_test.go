@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLargeDir creates a directory with enough files to be indexed.
+func newLargeDir(t *testing.T, c *restfs) string {
+	dir := filepath.Join(c.dir, "large")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < indexMinEntries; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d", i)), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// drainIndexRebuilds empties the rebuild queue, returning the queued dirs.
+func drainIndexRebuilds() []string {
+	var dirs []string
+	for {
+		select {
+		case dir := <-indexRebuild:
+			dirs = append(dirs, dir)
+		default:
+			return dirs
+		}
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "precompute-index-interval", "1h")
+	dir := newLargeDir(t, c)
+	parent, _ := os.Stat(c.dir)
+
+	if err := buildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	names, ok := readIndex(dir)
+	if !ok || len(names) != indexMinEntries {
+		t.Fatalf("index has %d names, fresh = %v", len(names), ok)
+	}
+	if _, err := os.Stat(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatal("index is not stored inside the directory")
+	}
+	if after, _ := os.Stat(c.dir); !after.ModTime().Equal(parent.ModTime()) {
+		t.Fatal("building the index changed the parent directory")
+	}
+	for _, name := range names {
+		if name == indexFile {
+			t.Fatal("index lists itself")
+		}
+	}
+	if list := do(c, "GET", "/large/", "").Body.String(); strings.Contains(list, indexFile) {
+		t.Fatal("index file is listed")
+	}
+	// Rebuilding a fresh index is a no-op.
+	if err := buildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readIndex(dir); !ok {
+		t.Fatal("index became stale by itself")
+	}
+}
+
+func TestIndexInvalidatedByPut(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "precompute-index-interval", "1h")
+	dir := newLargeDir(t, c)
+	if err := buildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	drainIndexRebuilds()
+
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, c, "/large/new", "x")
+	if _, ok := readIndex(dir); ok {
+		t.Fatal("index is fresh after a PUT")
+	}
+	if list := do(c, "GET", "/large/", "").Body.String(); !strings.Contains(list, "new\n") {
+		t.Fatal("stale index was served")
+	}
+	if queued := drainIndexRebuilds(); len(queued) == 0 || queued[0] != dir {
+		t.Fatalf("rebuilds queued after the write: %v", queued)
+	}
+	if err := buildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	names, ok := readIndex(dir)
+	if !ok || len(names) != indexMinEntries+1 {
+		t.Fatalf("rebuilt index has %d names, fresh = %v", len(names), ok)
+	}
+}
+
+func TestIndexOfSmallDirectoryIsRemoved(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "precompute-index-interval", "1h")
+	dir := newLargeDir(t, c)
+	if err := buildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "f0000"))
+	if err := buildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, indexFile)); !os.IsNotExist(err) {
+		t.Fatal("index of a small directory was kept")
+	}
+}
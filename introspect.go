@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// introspection describes how a request would be processed. Inspectors fill
+// in the fields they know about.
+type introspection struct {
+	WouldAuthenticate  bool     `json:"would_authenticate"`
+	AuthMethod         string   `json:"auth_method,omitempty"`
+	WouldAuthorize     bool     `json:"would_authorize"`
+	ACLRule            string   `json:"acl_rule,omitempty"`
+	CORSAllowed        *bool    `json:"cors_allowed,omitempty"`
+	RateLimited        bool     `json:"rate_limited"`
+	ServedBy           string   `json:"served_by"`
+	EffectiveDataDir   string   `json:"effective_data_dir"`
+	MiddlewaresApplied []string `json:"middlewares_applied"`
+}
+
+// An inspector reports whether its middleware applies to r and records what
+// it would do in res. It must not have side effects on the data directory.
+type inspector func(r *http.Request, res *introspection) bool
+
+type namedInspector struct {
+	priority int
+	name     string
+	inspect  inspector
+}
+
+var inspectors []namedInspector
+
+// registerInspector adds an inspector for the middleware name registered
+// with the same priority.
+func registerInspector(priority int, name string, inspect inspector) {
+	inspectors = append(inspectors, namedInspector{priority, name, inspect})
+}
+
+func init() {
+	registerEndpoint("/-/admin/introspect", serveIntrospect)
+}
+
+func serveIntrospect(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	p := q.Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+	method := strings.ToUpper(q.Get("method"))
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, path.Clean("/"+p), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req = req.WithContext(r.Context())
+	// The credentials of the admin request stand in for the inspected one.
+	for _, name := range []string{"Authorization", "X-Api-Key", "Cookie"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	if origin := q.Get("origin"); origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+
+	res := &introspection{
+		WouldAuthorize:     true,
+		ServedBy:           "local",
+		EffectiveDataDir:   c.dir,
+		MiddlewaresApplied: []string{},
+	}
+	if c.shards > 0 {
+		res.EffectiveDataDir = path.Join(c.dir, shardName(shardOf(req.URL.Path, c.shards)))
+	}
+	chain := append([]namedInspector(nil), inspectors...)
+	sort.SliceStable(chain, func(i, j int) bool { return chain[i].priority < chain[j].priority })
+	for _, in := range chain {
+		if in.inspect(req, res) {
+			res.MiddlewaresApplied = append(res.MiddlewaresApplied, in.name)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func introspect(t *testing.T, c *restfs, r *http.Request) *introspection {
+	t.Helper()
+	rec := serve(c.adminHandler(), r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("introspect: %d %s", rec.Code, rec.Body)
+	}
+	var res introspection
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return &res
+}
+
+func TestIntrospectCookieAuth(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "cookie-auth-secret", string(testCookieSecret))
+
+	res := introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/a.txt", ""))
+	if res.WouldAuthenticate || res.AuthMethod != "" {
+		t.Errorf("without cookie: %+v", res)
+	}
+	r := newRequest("GET", "/-/admin/introspect?path=/a.txt", "")
+	r.AddCookie(sessionCookie(t, &sessionToken{User: "alice"}))
+	res = introspect(t, c, r)
+	if !res.WouldAuthenticate || res.AuthMethod != "cookie" {
+		t.Errorf("with cookie: %+v", res)
+	}
+	if len(res.MiddlewaresApplied) != 1 || res.MiddlewaresApplied[0] != "cookie_auth" {
+		t.Errorf("middlewares = %v", res.MiddlewaresApplied)
+	}
+}
+
+func TestIntrospectCORS(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "cors-origins", "https://a.example")
+
+	for origin, want := range map[string]bool{"https://a.example": true, "https://b.example": false} {
+		res := introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/&origin="+origin, ""))
+		if res.CORSAllowed == nil || *res.CORSAllowed != want {
+			t.Errorf("%s: cors_allowed = %v, want %v", origin, res.CORSAllowed, want)
+		}
+	}
+	res := introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/", ""))
+	if res.CORSAllowed != nil {
+		t.Errorf("without origin: cors_allowed = %v", *res.CORSAllowed)
+	}
+	if len(res.MiddlewaresApplied) != 1 || res.MiddlewaresApplied[0] != "cors" {
+		t.Errorf("middlewares = %v", res.MiddlewaresApplied)
+	}
+}
+
+func TestIntrospectAuth(t *testing.T) {
+	c := newTestFS(t)
+	res := introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/", ""))
+	if res.WouldAuthenticate || res.AuthMethod != "" || !res.WouldAuthorize || len(res.MiddlewaresApplied) != 0 {
+		t.Errorf("no middlewares: %+v", res)
+	}
+
+	setFlag(t, "hmac-secret", "secret")
+	res = introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/a.txt&method=put", ""))
+	if !res.WouldAuthenticate || res.AuthMethod != "hmac" {
+		t.Errorf("signed PUT: %+v", res)
+	}
+	res = introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/a.txt", ""))
+	if res.WouldAuthenticate || len(res.MiddlewaresApplied) != 0 {
+		t.Errorf("unsigned GET: %+v", res)
+	}
+
+	// The cookie takes precedence over the signature; both apply in order.
+	setFlag(t, "cookie-auth-secret", string(testCookieSecret))
+	r := newRequest("GET", "/-/admin/introspect?path=/a.txt&method=PUT", "")
+	r.AddCookie(sessionCookie(t, &sessionToken{User: "alice"}))
+	res = introspect(t, c, r)
+	if res.AuthMethod != "cookie" {
+		t.Errorf("auth_method = %q, want cookie", res.AuthMethod)
+	}
+	if got := strings.Join(res.MiddlewaresApplied, ","); got != "cookie_auth,hmac" {
+		t.Errorf("middlewares = %s", got)
+	}
+}
+
+func TestIntrospectShards(t *testing.T) {
+	c := newShardedFS(t, 4)
+	for _, p := range []string{"/a.txt", "/dir/b.txt", "/c"} {
+		res := introspect(t, c, newRequest("GET", "/-/admin/introspect?path="+p, ""))
+		want := filepath.Join(c.dir, shardName(shardOf(p, c.shards)))
+		if res.EffectiveDataDir != want {
+			t.Errorf("%s: effective_data_dir = %s, want %s", p, res.EffectiveDataDir, want)
+		}
+	}
+
+	c = newTestFS(t)
+	res := introspect(t, c, newRequest("GET", "/-/admin/introspect?path=/a.txt", ""))
+	if res.EffectiveDataDir != c.dir {
+		t.Errorf("effective_data_dir = %s, want %s", res.EffectiveDataDir, c.dir)
+	}
+}
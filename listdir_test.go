@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type fakeFileInfo struct {
+	name  string
+	mtime time.Time
+}
+
+func (fi *fakeFileInfo) Name() string       { return fi.name }
+func (fi *fakeFileInfo) Size() int64        { return 0 }
+func (fi *fakeFileInfo) Mode() os.FileMode  { return 0666 }
+func (fi *fakeFileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *fakeFileInfo) IsDir() bool        { return false }
+func (fi *fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeDir returns n sorted entries of which every tenth file is deleted.
+func fakeDir(n int) []os.FileInfo {
+	t := time.Now()
+	fis := make([]os.FileInfo, 0, n+n/10)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file%08d", i)
+		fis = append(fis, &fakeFileInfo{name: name, mtime: t})
+		if i%10 == 0 {
+			fis = append(fis, &fakeFileInfo{name: name + tombstone, mtime: t.Add(time.Second)})
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis
+}
+
+func TestLiveNamesParallel(t *testing.T) {
+	fis := fakeDir(30000)
+	seq := liveNames([][]os.FileInfo{fis})
+	if len(seq) != 27000 {
+		t.Fatalf("%d live names, want 27000", len(seq))
+	}
+	// Split so that files and their tombstones end up in different chunks.
+	var chunks [][]os.FileInfo
+	for rest := fis; len(rest) > 0; {
+		n := 7
+		if n > len(rest) {
+			n = len(rest)
+		}
+		chunks, rest = append(chunks, rest[:n]), rest[n:]
+	}
+	if par := liveNames(chunks); !reflect.DeepEqual(par, seq) {
+		t.Fatalf("parallel listing differs: %d names, want %d", len(par), len(seq))
+	}
+}
+
+func benchmarkLiveNames(b *testing.B, split func([]os.FileInfo) [][]os.FileInfo) {
+	fis := fakeDir(1000000)
+	chunks := split(fis)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		liveNames(chunks)
+	}
+}
+
+func BenchmarkLiveNamesSequential(b *testing.B) {
+	benchmarkLiveNames(b, func(fis []os.FileInfo) [][]os.FileInfo { return [][]os.FileInfo{fis} })
+}
+
+func BenchmarkLiveNamesParallel(b *testing.B) {
+	benchmarkLiveNames(b, splitFileInfos)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// logJSON writes a single-line JSON log entry with the given op and fields.
+func logJSON(op string, fields map[string]interface{}) {
+	entry := map[string]interface{}{
+		"time": time.Now().Format(time.RFC3339Nano),
+		"op":   op,
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	log.Writer().Write(append(b, '\n'))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+var longPollTimeout = flag.Duration("long-poll-timeout", 30*time.Second, "Timeout for long polling on directory changes")
+
+var changes = newChangeBus()
+
+type changeBus struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+func newChangeBus() *changeBus {
+	return &changeBus{subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+func (b *changeBus) subscribe(dirs ...string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, dir := range dirs {
+		if b.subs[dir] == nil {
+			b.subs[dir] = make(map[chan struct{}]struct{})
+		}
+		b.subs[dir][ch] = struct{}{}
+	}
+	return ch
+}
+
+func (b *changeBus) unsubscribe(ch chan struct{}, dirs ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, dir := range dirs {
+		delete(b.subs[dir], ch)
+		if len(b.subs[dir]) == 0 {
+			delete(b.subs, dir)
+		}
+	}
+}
+
+// publish notifies subscribers watching the directory containing fullpath.
+func (b *changeBus) publish(fullpath string) {
+	dir := path.Dir(fullpath)
+	requestIndexRebuild(dir)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[dir] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func serveLongPoll(w http.ResponseWriter, r *http.Request, dirs []string) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "Invalid since parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	for i, dir := range dirs {
+		dirs[i] = path.Clean(dir)
+	}
+	ch := changes.subscribe(dirs...)
+	defer changes.unsubscribe(ch, dirs...)
+
+	if since.IsZero() || !changedSince(dirs, since) {
+		timer := time.NewTimer(*longPollTimeout)
+		defer timer.Stop()
+		select {
+		case <-ch:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+	serveFileList(w, dirs)
+}
+
+func changedSince(dirs []string, since time.Time) bool {
+	for _, dir := range dirs {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if fi.ModTime().After(since) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscribed reports whether a long poll is waiting on dir.
+func subscribed(dir string) bool {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	return len(changes.subs[dir]) > 0
+}
+
+// longPoll serves r, a long poll on /dir/, and returns a channel receiving
+// the response once the poll is waiting.
+func longPoll(t *testing.T, c *restfs, r *http.Request) <-chan *httptest.ResponseRecorder {
+	t.Helper()
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- serve(c, r) }()
+	waitFor(t, func() bool { return subscribed(filepath.Join(c.dir, "dir")) })
+	return done
+}
+
+func TestLongPollWakesOnChange(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/dir/a.txt", "a")
+
+	done := longPoll(t, c, newRequest("GET", "/dir/?wait-for-change=true", ""))
+	mustPut(t, c, "/dir/b.txt", "b")
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusOK || strings.Join(strings.Fields(rec.Body.String()), " ") != "a.txt b.txt" {
+			t.Fatalf("long poll = %d %q", rec.Code, rec.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll not woken by the change")
+	}
+	if subscribed(filepath.Join(c.dir, "dir")) {
+		t.Fatal("subscription left behind")
+	}
+}
+
+func TestLongPollSince(t *testing.T) {
+	c := newTestFS(t)
+	since := time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+	mustPut(t, c, "/dir/a.txt", "a")
+
+	// Changes after since are returned without waiting.
+	if rec := do(c, "GET", "/dir/?wait-for-change=true&since="+since, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "a.txt") {
+		t.Fatalf("long poll with earlier changes = %d %q", rec.Code, rec.Body)
+	}
+	if rec := do(c, "GET", "/dir/?wait-for-change=true&since=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid since = %d", rec.Code)
+	}
+}
+
+func TestLongPollTimeout(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "long-poll-timeout", "20ms")
+	mustPut(t, c, "/dir/a.txt", "a")
+	since := time.Now().Add(time.Minute).Format(time.RFC3339Nano)
+
+	for _, q := range []string{"", "&since=" + since} {
+		if rec := do(c, "GET", "/dir/?wait-for-change=true"+q, ""); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%q: timed out long poll = %d %q", q, rec.Code, rec.Body)
+		}
+	}
+	if subscribed(filepath.Join(c.dir, "dir")) {
+		t.Fatal("subscription left behind")
+	}
+}
+
+func TestLongPollClientDisconnect(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/dir/a.txt", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := longPoll(t, c, newRequest("GET", "/dir/?wait-for-change=true", "").WithContext(ctx))
+	cancel()
+	select {
+	case rec := <-done:
+		if rec.Body.Len() != 0 {
+			t.Fatalf("response after disconnect: %q", rec.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll still waiting after the client went away")
+	}
+	if subscribed(filepath.Join(c.dir, "dir")) {
+		t.Fatal("subscription left behind")
+	}
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -32,9 +41,16 @@ var (
 var (
 	accessLogWriter = new(webutil.ConsoleLogWriter)
 	middlewares     []*middleware
+	endpoints       = make(map[string]endpoint)
+	gcHooks         []func(dir string)
 )
 
-const tombstone = ".restfs-deleted"
+const (
+	tombstone  = ".restfs-deleted"
+	tempPrefix = ".restfs-tmp-"
+)
+
+var tempSeq uint64
 
 type middleware struct {
 	priority int
@@ -51,87 +67,327 @@ func registerMiddleware(priority int, wrap func(http.Handler) http.Handler) {
 	middlewares = append(middlewares, &middleware{priority: priority, wrap: wrap})
 }
 
+// registerGCHook adds a function run on the data directory after each GC.
+func registerGCHook(hook func(dir string)) {
+	gcHooks = append(gcHooks, hook)
+}
+
+type endpoint func(c *restfs, w http.ResponseWriter, r *http.Request)
+
+// registerEndpoint serves requests to the exact path p with e instead of the
+// file at p.
+func registerEndpoint(p string, e endpoint) {
+	endpoints[p] = e
+}
+
 type restfs struct {
-	dir string
+	dir    string
+	shards int
+	gc     *gc
+	writes sync.WaitGroup
 }
 
 func (c *restfs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fullpath := path.Join(c.dir, r.URL.Path)
+	if strings.HasPrefix(r.URL.Path, adminPrefix) {
+		// Served by the admin listener only.
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if e, ok := endpoints[r.URL.Path]; ok {
+		e(c, w, r)
+		return
+	}
+	fullpath := c.fullpath(r.URL.Path)
+	if isReserved(path.Base(fullpath)) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
 	var (
 		fi  os.FileInfo
 		err error
 	)
 	switch r.Method {
 	case "GET":
-		s := stat(fullpath)
-		if s == nil {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		if _, err := safeStatFollow(fullpath, *symlinkMaxDepth); err == errSymlinkDepth {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if s.IsDir() {
-			serveFileList(w, fullpath)
+		lk := c.readThrough(w, r, fullpath, lookupFile(fullpath))
+		fallback := false
+		if lk.stat == nil {
+			if fb := c.fallbackFile(r.URL.Path, fullpath); fb != "" {
+				fullpath = fb
+				lk = lookupFile(fb)
+				fallback = true
+			}
+		}
+		s := lk.stat
+		if s == nil || s.IsDir() {
+			dirs := c.fallbackDirs(r.URL.Path, c.dirpaths(r.URL.Path))
+			if len(dirs) > 0 {
+				serveDir(w, r, dirs)
+			} else if g := findGenerator(path.Clean("/" + r.URL.Path)); s == nil && g != nil {
+				serveGenerated(w, r, g)
+			} else {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			}
+		} else if src := writeBuf.locate(fullpath); shouldGunzip(r, fullpath) {
+			serveGunzipped(w, src)
+		} else if rule := findTransform(r, fullpath); rule != nil {
+			serveTransformed(w, r, src, rule)
 		} else {
 			w.Header().Set("Etag", genEtag(s))
-			http.ServeFile(w, r, fullpath)
+			if lk.ctype != "" {
+				w.Header().Set("Content-Type", lk.ctype)
+			}
+			setMetadataHeaders(w, fullpath, s)
+			// Counts are stored next to the file, which the read-only
+			// fallback directory cannot take.
+			if !fallback {
+				recordAccess(fullpath)
+			}
+			cw := &countingWriter{ResponseWriter: w}
+			if algo := r.URL.Query().Get("stream-hash"); algo != "" {
+				serveFileWithHash(cw, r, src, algo)
+			} else if lk.data != nil {
+				http.ServeContent(cw, r, path.Base(fullpath), s.ModTime(), bytes.NewReader(lk.data))
+			} else {
+				http.ServeFile(cw, r, src)
+			}
+			if cw.ok() {
+				bandwidth.reportRead(c.bandwidthDir(r.URL.Path), cw.n)
+			}
 		}
 		return
 	case "PUT":
-		fi, err = os.Stat(fullpath)
-		if err == nil && fi.IsDir() {
+		c.writes.Add(1)
+		defer c.writes.Done()
+		if dirOnly, _ := strconv.ParseBool(r.Header.Get("X-Restfs-Dir-Only")); dirOnly {
+			c.serveMkdir(w, r, fullpath)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.Error(w, "cannot PUT to directory path; use X-Restfs-Dir-Only header to create directories", http.StatusBadRequest)
+			return
+		}
+		unlock := pathLocks.lock(fullpath)
+		defer unlock()
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, stat(fullpath)) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+		fi, err = entryStat(fullpath)
+		if (err == nil && fi.IsDir()) || len(c.dirpaths(r.URL.Path)) > 0 {
 			http.Error(w, "Cannot overwrite directory", http.StatusBadRequest)
 			return
 		}
+		if p := c.fileAncestor(r.URL.Path); p != "" {
+			http.Error(w, "File exists at "+p, http.StatusConflict)
+			return
+		}
+		if !hasDiskSpace(c.dir, r.ContentLength) {
+			http.Error(w, "Insufficient storage", http.StatusInsufficientStorage)
+			return
+		}
+		if target := r.Header.Get(symlinkTargetHeader); target != "" {
+			c.serveSymlinkPut(w, r, fullpath, target)
+			return
+		}
+		if noop, _ := strconv.ParseBool(r.Header.Get("X-Restfs-Noop")); noop {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+			w.Header().Set("X-Restfs-Noop", "true")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		if err == nil || os.IsNotExist(err) {
-			err = c.saveFile(fullpath, r.Body)
+			var n int64
+			n, err = c.saveFile(fullpath, r.Body)
 			r.Body.Close()
+			if err == nil {
+				bandwidth.reportWrite(c.bandwidthDir(r.URL.Path), n)
+				err = saveMeta(fullpath, r.Header)
+			}
+			if err == nil {
+				changes.publish(fullpath)
+			}
 		}
 	case "DELETE":
-		fi, err = os.Stat(fullpath)
-		if err == nil {
-			if fi.IsDir() {
-				recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
-				if recursive {
-					err = c.removeAll(fullpath)
-				} else {
-					http.Error(w, "Cannot remove directory; forgot recursive=true?", http.StatusBadRequest)
-					return
-				}
-			} else {
+		c.writes.Add(1)
+		defer c.writes.Done()
+		unlock := pathLocks.lock(fullpath)
+		defer unlock()
+		buffered := writeBuf.locate(fullpath) != fullpath
+		if err = writeBuf.drop(fullpath); err != nil {
+			break
+		}
+		fi, err = entryStat(fullpath)
+		if err == nil && !fi.IsDir() {
+			err = c.remove(fullpath)
+		} else if os.IsNotExist(err) && buffered {
+			// Never flushed, so only the sidecars are left.
+			if err = removeSidecars(fullpath); err == nil {
+				changes.publish(fullpath)
+			}
+		} else if os.IsNotExist(err) && c.fallbackFile(r.URL.Path, fullpath) != "" {
+			// Shadow the fallback copy with a tombstone in the primary.
+			if err = os.MkdirAll(path.Dir(fullpath), 0777); err == nil {
 				err = c.remove(fullpath)
 			}
-		} else if os.IsNotExist(err) {
-			return
+		} else if err == nil || os.IsNotExist(err) {
+			dirs := c.dirpaths(r.URL.Path)
+			if len(dirs) == 0 {
+				return
+			}
+			recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
+			if !recursive {
+				http.Error(w, "Cannot remove directory; forgot recursive=true?", http.StatusBadRequest)
+				return
+			}
+			for _, dir := range dirs {
+				if err = writeBuf.dropAll(dir); err != nil {
+					break
+				}
+				if err = c.removeAll(dir); err != nil {
+					break
+				}
+			}
 		}
+	case "COPY":
+		c.serveCopy(w, r, fullpath)
+		return
 	default:
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		code, msg := classifyFSError(err)
+		http.Error(w, msg, code)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func (c *restfs) saveFile(fullpath string, r io.Reader) error {
-	dir, _ := path.Split(fullpath)
+func (c *restfs) serveMkdir(w http.ResponseWriter, r *http.Request, fullpath string) {
+	if p := c.fileAncestor(r.URL.Path); p != "" {
+		http.Error(w, "File exists at "+p, http.StatusConflict)
+		return
+	}
+	unlock := pathLocks.lock(fullpath)
+	defer unlock()
+	if fi := stat(fullpath); fi != nil {
+		if fi.IsDir() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			http.Error(w, "File exists at the path", http.StatusConflict)
+		}
+		return
+	}
+	// A deleted file stays on disk until the GC collects it.
+	if tpath, tstat, err := findTombstone(fullpath); err == nil {
+		if err := collect(tpath, tstat); err != nil {
+			code, msg := classifyFSError(err)
+			http.Error(w, msg, code)
+			return
+		}
+	}
+	if err := os.MkdirAll(fullpath, 0777); err != nil {
+		code, msg := classifyFSError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	changes.publish(fullpath)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (c *restfs) fullpath(p string) string {
+	p = path.Clean("/" + p)
+	if c.shards > 0 {
+		return path.Join(c.dir, shardName(shardOf(p, c.shards)), p)
+	}
+	return path.Join(c.dir, p)
+}
+
+func (c *restfs) saveFile(fullpath string, r io.Reader) (int64, error) {
+	hash := sha256.New()
+	r = io.TeeReader(r, hash)
+	var n int64
+	var err error
+	if writeBuf == nil {
+		n, err = writeFileAtomic(fullpath, r)
+	} else if n, err = writeFileAtomic(writeBuf.path(fullpath), r); err == nil {
+		writeBuf.add(fullpath, n)
+		dir, _ := path.Split(fullpath)
+		err = os.MkdirAll(dir, 0777)
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, writeSidecar(fullpath, checksumSuffix, &checksum{
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+		Size:   n,
+	})
+}
+
+func tempPath(dir string) string {
+	return fmt.Sprintf("%s%s%d-%d", dir, tempPrefix, os.Getpid(), atomic.AddUint64(&tempSeq, 1))
+}
+
+// writeFileAtomic writes r to a temporary file and renames it to dst.
+func writeFileAtomic(dst string, r io.Reader) (int64, error) {
+	dir, _ := path.Split(dst)
 	if err := os.MkdirAll(dir, 0777); err != nil {
-		return err
+		return 0, err
 	}
-	f, err := os.OpenFile(fullpath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	tmp := tempPath(dir)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if _, err := io.Copy(f, r); err != nil {
-		return err
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return n, err
+}
+
+// drain waits for in-flight writes to finish and flushes the directory
+// entries of the data directory to disk.
+func (c *restfs) drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		c.writes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Gave up waiting for in-flight writes after %s", timeout)
+	}
+	writeBuf.flush()
+
+	d, err := os.Open(c.dir)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		log.Print(err)
 	}
-	return nil
 }
 
 func (c *restfs) remove(fullpath string) error {
-	f, err := os.Create(fullpath + tombstone)
+	f, err := os.Create(tombstonePath(fullpath))
 	if err == nil {
 		f.Close()
+		changes.publish(fullpath)
 	}
 	return err
 }
@@ -141,7 +397,7 @@ func (c *restfs) removeAll(fullpath string) error {
 		if err != nil {
 			return err
 		}
-		if stat.IsDir() || strings.HasSuffix(name, tombstone) {
+		if stat.IsDir() || isReserved(stat.Name()) {
 			return nil
 		}
 		return c.remove(name)
@@ -149,60 +405,159 @@ func (c *restfs) removeAll(fullpath string) error {
 }
 
 type gc struct {
-	dir    string
-	invoke chan struct{}
+	dir       string
+	roots     []string
+	invoke    chan struct{}
+	heartbeat chan struct{}
+	running   int32
+	progress  int64 // UnixNano of the last progress of a running GC
 }
 
-func newGC(dir string) *gc {
+// newGC returns a GC of the data directory dir, which collects each of roots
+// independently.
+func newGC(dir string, roots []string) *gc {
 	g := &gc{
-		dir:    dir,
-		invoke: make(chan struct{}, 1),
+		dir:       dir,
+		roots:     roots,
+		invoke:    make(chan struct{}, 1),
+		heartbeat: make(chan struct{}),
 	}
 	go g.loop()
 	return g
 }
 
-func (g *gc) loop() {
-	remove := func(s string) error {
-		log.Printf("Remove %s", s)
-		if err := os.Remove(s); err != nil && !os.IsNotExist(err) {
-			return err
-		}
+const (
+	reasonTombstoneNewer  = "tombstone_newer"
+	reasonTombstoneStale  = "tombstone_stale"
+	reasonDataFileMissing = "data_file_missing"
+)
+
+func removeWithReason(s, reason string) error {
+	if err := os.Remove(s); os.IsNotExist(err) {
 		return nil
+	} else if err != nil {
+		return err
 	}
-	for range g.invoke {
-		log.Print("GC started")
-		start := time.Now()
-		err := filepath.Walk(g.dir, func(name string, stat os.FileInfo, err error) error {
-			if err != nil {
-				return err
+	logJSON("gc_remove", map[string]interface{}{"path": s, "reason": reason})
+	gcRemoved.WithLabelValues(reason).Inc()
+	return nil
+}
+
+func (g *gc) loop() {
+	for {
+		select {
+		case g.heartbeat <- struct{}{}:
+			continue
+		case _, ok := <-g.invoke:
+			if !ok {
+				return
 			}
-			if stat.IsDir() || !strings.HasSuffix(name, tombstone) {
+		}
+		g.run()
+	}
+}
+
+func (g *gc) run() {
+	g.touch()
+	atomic.StoreInt32(&g.running, 1)
+	defer func() {
+		atomic.StoreInt32(&g.running, 0)
+		if err := recover(); err != nil {
+			panicsRecovered.Inc()
+			log.Printf("GC panic: %v\n%s", err, debug.Stack())
+		}
+	}()
+	var found int64
+	for _, root := range g.roots {
+		// A failing shard does not stop the others.
+		n, _ := g.collectAll(root)
+		found += n
+	}
+	tombstoneCount.Set(found)
+	for _, hook := range gcHooks {
+		g.touch()
+		hook(g.dir)
+	}
+}
+
+// touch records that a running GC is making progress.
+func (g *gc) touch() {
+	atomic.StoreInt64(&g.progress, time.Now().UnixNano())
+}
+
+// collectAll collects the tombstones below root.
+func (g *gc) collectAll(root string) (int64, error) {
+	log.Printf("GC started on %s", root)
+	start := time.Now()
+	var found int64
+	err := filepath.Walk(root, func(name string, stat os.FileInfo, err error) error {
+		g.touch()
+		if err != nil {
+			// Sidecars may be gone by the time the walk reaches them.
+			if os.IsNotExist(err) {
 				return nil
 			}
-			fname := name[:len(name)-len(tombstone)]
-			fstat, err := os.Stat(fname)
-			if err == nil {
-				if !fstat.ModTime().After(stat.ModTime()) {
-					if err = remove(fname); err != nil {
-						return err
-					}
-				}
-				return remove(name)
-			} else if os.IsNotExist(err) {
-				return remove(name)
-			}
 			return err
-		})
-		took := time.Since(start)
-		if err == nil {
-			log.Printf("GC has finished in %v", took)
-		} else {
-			log.Printf("GC has aborted in %v with error: %v", took, err)
 		}
+		if stat.IsDir() || !isTombstone(name) {
+			return nil
+		}
+		found++
+		return collect(name, stat)
+	})
+	took := time.Since(start)
+	if err == nil {
+		log.Printf("GC on %s has finished in %v", root, took)
+	} else {
+		log.Printf("GC on %s has aborted in %v with error: %v", root, took, err)
+	}
+	return found, err
+}
+
+// alive reports whether the GC goroutine answers a heartbeat within timeout
+// or, while it is running, has made progress within gcStallTimeout.
+func (g *gc) alive(timeout time.Duration) bool {
+	if atomic.LoadInt32(&g.running) == 1 {
+		return time.Since(time.Unix(0, atomic.LoadInt64(&g.progress))) < gcStallTimeout
+	}
+	select {
+	case <-g.heartbeat:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
 
+// collect removes the tombstone at name along with the data file it shadows.
+func collect(name string, stat os.FileInfo) error {
+	fname, _ := tombstoneTarget(name)
+	// A tombstone hiding a file in the fallback directory has to stay.
+	keep := shadowsFallback(fname)
+	fstat, err := entryStat(fname)
+	if src := writeBuf.locate(fname); src != fname {
+		// The buffered upload is flushed over the data file later.
+		fstat, err = os.Stat(src)
+	}
+	if err == nil {
+		if fstat.ModTime().After(stat.ModTime()) {
+			return removeWithReason(name, reasonTombstoneStale)
+		}
+		if err = removeWithReason(fname, reasonTombstoneNewer); err != nil {
+			return err
+		}
+		if err = removeSidecars(fname); err != nil || keep {
+			return err
+		}
+		return removeWithReason(name, reasonTombstoneNewer)
+	} else if os.IsNotExist(err) {
+		if err = removeSidecars(fname); err != nil || keep {
+			return err
+		}
+		return removeWithReason(name, reasonDataFileMissing)
+	}
+	return err
+}
+
 func (g *gc) Start() {
 	select {
 	case g.invoke <- struct{}{}:
@@ -211,15 +566,25 @@ func (g *gc) Start() {
 }
 
 func stat(fullpath string) os.FileInfo {
-	astat, err := os.Stat(fullpath)
+	src := writeBuf.locate(fullpath)
+	astat, err := os.Stat(src)
 	if err != nil {
 		return nil
 	}
 	if astat.IsDir() {
 		return astat
 	}
+	if src == fullpath {
+		// A symlink to a deleted file is dangling.
+		if lstat, err := os.Lstat(fullpath); err == nil && isSymlink(lstat) {
+			target, err := filepath.EvalSymlinks(fullpath)
+			if err != nil || stat(target) == nil {
+				return nil
+			}
+		}
+	}
 
-	bstat, err := os.Stat(fullpath + tombstone)
+	_, bstat, err := findTombstone(fullpath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return astat
@@ -227,7 +592,14 @@ func stat(fullpath string) os.FileInfo {
 		log.Print(err)
 		return nil
 	}
-	if astat.ModTime().After(bstat.ModTime()) {
+	// A buffered upload replaces whatever is in the data directory.
+	mtime := astat.ModTime()
+	if src == fullpath {
+		if lstat, err := entryStat(fullpath); err == nil {
+			mtime = lstat.ModTime()
+		}
+	}
+	if mtime.After(bstat.ModTime()) {
 		return astat
 	}
 	return nil
@@ -239,35 +611,177 @@ func genEtag(s os.FileInfo) string {
 	return fmt.Sprintf(`W/"%x-%x"`, s.Size(), t)
 }
 
-func serveFileList(w http.ResponseWriter, s string) {
-	fis, err := ioutil.ReadDir(s)
-	if err != nil {
-		log.Print(err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+// serveDir serves the directory stored in dirs, which has more than one
+// entry when the directory exists in several shards.
+func serveDir(w http.ResponseWriter, r *http.Request, dirs []string) {
+	q := r.URL.Query()
+	if q.Get("diff") != "" {
+		serveDiff(w, r, dirs)
+		return
+	}
+	if wait, _ := strconv.ParseBool(q.Get("wait-for-change")); wait {
+		serveLongPoll(w, r, dirs)
 		return
 	}
+	if q.Get("format") == "csv" {
+		serveCSVList(w, r, dirs)
+		return
+	}
+	serveFileList(w, dirs)
+}
 
-	tombstones := make(map[string]os.FileInfo)
-	for _, fi := range fis {
-		name := fi.Name()
-		if strings.HasSuffix(name, tombstone) {
-			name = name[:len(name)-len(tombstone)]
-			tombstones[name] = fi
+func serveFileList(w http.ResponseWriter, dirs []string) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		list, err := indexedListDir(dir)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		list = filterFallback(dir, list)
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
 		}
 	}
+	if len(dirs) > 1 {
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\n", name)
+	}
+}
 
+func listDir(s string) ([]string, error) {
+	fis, err := readDir(s)
+	if err != nil {
+		return nil, err
+	}
+	return liveNames(splitFileInfos(fis)), nil
+}
+
+// readDir returns the entries of the directory s sorted by name, with uploads
+// still in the write buffer in place of the files on disk.
+func readDir(s string) ([]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(s)
+	if err != nil {
+		return nil, err
+	}
+	if buffered := writeBuf.pendingIn(s); len(buffered) > 0 {
+		for i, fi := range fis {
+			if bfi, ok := buffered[fi.Name()]; ok {
+				fis[i] = bfi
+				delete(buffered, fi.Name())
+			}
+		}
+		for _, fi := range buffered {
+			fis = append(fis, fi)
+		}
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	}
+	return fis, nil
+}
+
+// walkDir calls fn for every entry below dir as returned by readDir, parents
+// first. Tombstones and reserved files are passed too. fn returns
+// filepath.SkipDir to skip the contents of a directory.
+func walkDir(dir string, fn func(name string, fi os.FileInfo) error) error {
+	fis, err := readDir(dir)
+	if err != nil {
+		return err
+	}
 	for _, fi := range fis {
-		name := fi.Name()
-		if strings.HasSuffix(name, tombstone) {
+		name := filepath.Join(dir, fi.Name())
+		if err := fn(name, fi); err == filepath.SkipDir && fi.IsDir() {
 			continue
+		} else if err != nil {
+			return err
 		}
 		if fi.IsDir() {
-			name += "/"
-		} else if ts := tombstones[name]; ts != nil && !fi.ModTime().After(ts.ModTime()) {
-			continue
+			if err := walkDir(name, fn); err != nil {
+				return err
+			}
 		}
-		fmt.Fprintf(w, "%s\n", name)
 	}
+	return nil
+}
+
+// liveNames returns the names of the entries in chunks that are not reserved
+// or deleted. Each chunk is processed in its own goroutine.
+func liveNames(chunks [][]os.FileInfo) []string {
+	var tombstones sync.Map
+	forEachChunk(chunks, func(_ int, chunk []os.FileInfo) {
+		for _, fi := range chunk {
+			if target, ok := tombstoneTarget(fi.Name()); ok {
+				tombstones.Store(target, fi)
+			}
+		}
+	})
+
+	live := make([][]string, len(chunks))
+	forEachChunk(chunks, func(i int, chunk []os.FileInfo) {
+		for _, fi := range chunk {
+			name := fi.Name()
+			if isReserved(name) {
+				continue
+			}
+			if fi.IsDir() {
+				name += "/"
+			} else if ts, ok := tombstones.Load(name); ok && !fi.ModTime().After(ts.(os.FileInfo).ModTime()) {
+				continue
+			} else if isSymlink(fi) && *symlinkPolicy == symlinkPreserve {
+				name += "@"
+			}
+			live[i] = append(live[i], name)
+		}
+	})
+
+	var names []string
+	for _, l := range live {
+		names = append(names, l...)
+	}
+	return names
+}
+
+// parallelListThreshold is the number of directory entries above which
+// listDir spreads its work over all CPUs.
+const parallelListThreshold = 10000
+
+func splitFileInfos(fis []os.FileInfo) [][]os.FileInfo {
+	n := runtime.NumCPU()
+	if len(fis) < parallelListThreshold || n < 2 {
+		return [][]os.FileInfo{fis}
+	}
+	size := (len(fis) + n - 1) / n
+	chunks := make([][]os.FileInfo, 0, n)
+	for len(fis) > 0 {
+		if len(fis) < size {
+			size = len(fis)
+		}
+		chunks = append(chunks, fis[:size])
+		fis = fis[size:]
+	}
+	return chunks
+}
+
+func forEachChunk(chunks [][]os.FileInfo, fn func(i int, chunk []os.FileInfo)) {
+	if len(chunks) == 1 {
+		fn(0, chunks[0])
+		return
+	}
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []os.FileInfo) {
+			defer wg.Done()
+			fn(i, chunk)
+		}(i, chunk)
+	}
+	wg.Wait()
 }
 
 func openAccessLog() {
@@ -288,11 +802,70 @@ func openAccessLog() {
 	}
 }
 
+func listenAndServe(srv *graceful.Server) error {
+	l, err := systemdListener()
+	if err != nil {
+		return err
+	}
+	if l != nil {
+		log.Printf("Using socket passed by systemd: %s", l.Addr())
+		l = keepAliveListener{Listener: l, period: srv.TCPKeepAlive}
+	}
+	if *tlsCert == "" {
+		if l != nil {
+			return srv.Serve(l)
+		}
+		return srv.ListenAndServe()
+	}
+	config, err := newTLSConfig()
+	if err != nil {
+		return err
+	}
+	log.Print("TLS enabled")
+	if l != nil {
+		return srv.Serve(tls.NewListener(l, config))
+	}
+	return srv.ListenAndServeTLSConfig(config)
+}
+
 func main() {
 	flag.Parse()
+	if errs := validateConfig(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "restfs: %v\n", err)
+		}
+		os.Exit(2)
+	}
 
-	log.Printf("Data directory: %s", *dataDir)
-	var h http.Handler = &restfs{*dataDir}
+	dir := dataDirPath()
+	log.Printf("Data directory: %s", dir)
+	fs := &restfs{dir: dir, shards: shards.count}
+	if *createDataDir {
+		for _, d := range fs.shardDirs() {
+			if err := os.MkdirAll(d, 0777); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *startupRepairFlag {
+		if err := startupRepair(dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tmpfsBufferDir != "" {
+		b, err := newWriteBuffer(dir, *tmpfsBufferDir, *tmpfsBufferThreshold)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeBuf = b
+		go writeBuf.loop(*tmpfsFlushInterval)
+		log.Printf("Buffering uploads in %s", *tmpfsBufferDir)
+	}
+	if *fallbackDir != "" {
+		fallbackPrimary = fs
+		log.Printf("Fallback directory: %s", *fallbackDir)
+	}
+	var h http.Handler = fs
 
 	sort.Sort(sort.Reverse(byPriority(middlewares)))
 	for _, m := range middlewares {
@@ -303,7 +876,13 @@ func main() {
 	h = webutil.Logger(h, accessLogWriter)
 	sigm.Handle(syscall.SIGHUP, openAccessLog)
 
-	g := newGC(*dataDir)
+	startIndexer(dir)
+	if *adminListen != "" {
+		go serveAdmin(fs)
+	}
+
+	g := newGC(dir, fs.shardDirs())
+	fs.gc = g
 	g.Start()
 	sigm.Handle(syscall.SIGUSR1, g.Start)
 	if *gcInterval > 0 {
@@ -315,6 +894,31 @@ func main() {
 		}()
 	}
 
-	log.Printf("Server started at %s", *listen)
-	graceful.Run(*listen, *gracefulTimeout, webutil.Recoverer(h, os.Stderr))
+	addr, err := listenAddr()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *bindInterface != "" {
+		log.Printf("Bound to interface %s: %s", *bindInterface, addr)
+	}
+	srv := &graceful.Server{
+		Timeout:      *gracefulTimeout,
+		TCPKeepAlive: 3 * time.Minute,
+		ConnState:    trackConnState,
+		Server: &http.Server{
+			Addr:     addr,
+			Handler:  recoverMiddleware(h),
+			ErrorLog: serverErrorLog,
+		},
+	}
+	startPushgateway()
+	log.Printf("Server started at %s", addr)
+	if err := listenAndServe(srv); err != nil {
+		if opErr, ok := err.(*net.OpError); !ok || opErr.Op != "accept" {
+			log.Fatal(err)
+		}
+	}
+	fs.drain(*gracefulTimeout)
+	pushMetrics()
+	log.Print("Server stopped")
 }
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// setFlag sets the named flag for the duration of the test.
+func setFlag(t testing.TB, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag %s", name)
+	}
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// newTestFS returns a restfs serving a fresh temporary data directory.
+func newTestFS(t *testing.T) *restfs {
+	t.Helper()
+	dir := t.TempDir()
+	setFlag(t, "data-dir", dir)
+	return &restfs{dir: dir}
+}
+
+// newRequest returns a request with the given method, path and body.
+func newRequest(method, p, body string) *http.Request {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	return httptest.NewRequest(method, p, r)
+}
+
+// serve serves r on h and returns the recorded response.
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+// do serves a request with the given method, path and body on h.
+func do(h http.Handler, method, p, body string) *httptest.ResponseRecorder {
+	return serve(h, newRequest(method, p, body))
+}
+
+// mustPut stores body at p and fails the test unless it succeeds.
+func mustPut(t *testing.T, h http.Handler, p, body string) {
+	t.Helper()
+	if rec := do(h, "PUT", p, body); rec.Code != http.StatusOK {
+		t.Fatalf("PUT %s: %d %s", p, rec.Code, rec.Body)
+	}
+}
+
+// withTestAuth wraps h with the authorization callback, answered by allow.
+func withTestAuth(t *testing.T, h http.Handler, allow func(req *authRequest) bool) http.Handler {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req authRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(&authDecision{Allowed: allow(&req), User: req.Headers["Authorization"]})
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { callbackAuth = nil })
+	return withAuthCallback(h, srv.URL)
+}
+
+// counterValue returns the current value of c.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// partialFiles returns the temporary files left below dir.
+func partialFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var found []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && strings.Contains(filepath.Base(p), tempPrefix) {
+			found = append(found, p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestDrainWaitsForWrites(t *testing.T) {
+	c := newTestFS(t)
+	pr, pw := io.Pipe()
+	put := make(chan int)
+	go func() {
+		r := newRequest("PUT", "/big.bin", "")
+		r.Body = pr
+		put <- serve(c, r).Code
+	}()
+	pw.Write([]byte("first half,"))
+
+	drained := make(chan struct{})
+	go func() {
+		c.drain(5 * time.Second)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("drain returned while a PUT was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if code := <-put; code != http.StatusOK {
+		t.Fatalf("PUT: %d", code)
+	}
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not return after the PUT finished")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, "big.bin"))
+	if err != nil || string(b) != "first half,second half" {
+		t.Fatalf("file = %q, %v", b, err)
+	}
+	if found := partialFiles(t, c.dir); len(found) > 0 {
+		t.Fatalf("partial files left: %v", found)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	c := newTestFS(t)
+	captureLog(t)
+	c.writes.Add(1)
+	defer c.writes.Done()
+	start := time.Now()
+	c.drain(50 * time.Millisecond)
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("drain ignored its timeout")
+	}
+}
+
+func TestInterruptedPutLeavesNoPartialFile(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "old")
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("partial"))
+		pw.CloseWithError(errors.New("client went away"))
+	}()
+	r := newRequest("PUT", "/a.txt", "")
+	r.Body = pr
+	if rec := serve(c, r); rec.Code == http.StatusOK {
+		t.Fatal("interrupted PUT succeeded")
+	}
+	c.drain(time.Second)
+	if rec := do(c, "GET", "/a.txt", ""); rec.Body.String() != "old" {
+		t.Fatalf("GET after interrupted PUT: %q", rec.Body)
+	}
+	if found := partialFiles(t, c.dir); len(found) > 0 {
+		t.Fatalf("partial files left: %v", found)
+	}
+}
+
+func TestPutDirectoryPath(t *testing.T) {
+	c := newTestFS(t)
+	for _, p := range []string{"/dir/", "/a/b/"} {
+		rec := do(c, "PUT", p, "data")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "cannot PUT to directory path") {
+			t.Errorf("PUT %s: %d %q", p, rec.Code, rec.Body)
+		}
+		if _, err := os.Stat(filepath.Join(c.dir, p)); !os.IsNotExist(err) {
+			t.Errorf("PUT %s created %v", p, err)
+		}
+	}
+
+	r := newRequest("PUT", "/dir/", "")
+	r.Header.Set("X-Restfs-Dir-Only", "true")
+	if rec := serve(c, r); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT with X-Restfs-Dir-Only: %d %s", rec.Code, rec.Body)
+	}
+	if fi, err := os.Stat(filepath.Join(c.dir, "dir")); err != nil || !fi.IsDir() {
+		t.Fatalf("directory not created: %v", err)
+	}
+}
+
+func mkdir(h http.Handler, p string) *httptest.ResponseRecorder {
+	r := newRequest("PUT", p, "")
+	r.Header.Set("X-Restfs-Dir-Only", "true")
+	return serve(h, r)
+}
+
+func TestMkdir(t *testing.T) {
+	c := newTestFS(t)
+	if rec := mkdir(c, "/a/b"); rec.Code != http.StatusCreated {
+		t.Fatalf("new directory: %d %s", rec.Code, rec.Body)
+	}
+	if fi, err := os.Stat(filepath.Join(c.dir, "a/b")); err != nil || !fi.IsDir() {
+		t.Fatalf("directory not created: %v", err)
+	}
+	if rec := mkdir(c, "/a/b"); rec.Code != http.StatusOK {
+		t.Fatalf("existing directory: %d %s", rec.Code, rec.Body)
+	}
+	mustPut(t, c, "/f.txt", "data")
+	if rec := mkdir(c, "/f.txt"); rec.Code != http.StatusConflict {
+		t.Fatalf("existing file: %d %s", rec.Code, rec.Body)
+	}
+
+	// A deleted file no longer conflicts.
+	do(c, "DELETE", "/f.txt", "")
+	if rec := mkdir(c, "/f.txt"); rec.Code != http.StatusCreated {
+		t.Fatalf("deleted file: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(c, "GET", "/f.txt/", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET new directory: %d", rec.Code)
+	}
+}
+
+func TestMkdirBufferedFile(t *testing.T) {
+	c := newTestFS(t)
+	withWriteBuffer(t, c)
+	mustPut(t, c, "/f.txt", "buffered")
+	if rec := mkdir(c, "/f.txt"); rec.Code != http.StatusConflict {
+		t.Fatalf("buffered file: %d %s", rec.Code, rec.Body)
+	}
+	writeBuf.flush()
+	if rec := do(c, "GET", "/f.txt", ""); rec.Body.String() != "buffered" {
+		t.Fatalf("GET after flush: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func putNoop(h http.Handler, p, body string) *httptest.ResponseRecorder {
+	r := newRequest("PUT", p, body)
+	r.Header.Set("X-Restfs-Noop", "true")
+	return serve(h, r)
+}
+
+func TestPutNoop(t *testing.T) {
+	c := newTestFS(t)
+	rec := putNoop(c, "/dir/a.txt", "data")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Restfs-Noop") != "true" {
+		t.Fatalf("noop PUT: %d %v", rec.Code, rec.Header())
+	}
+	if names, _ := ioutil.ReadDir(c.dir); len(names) != 0 {
+		t.Fatalf("noop PUT wrote %d entries", len(names))
+	}
+
+	mustPut(t, c, "/b.txt", "old")
+	putNoop(c, "/b.txt", "new")
+	if rec := do(c, "GET", "/b.txt", ""); rec.Body.String() != "old" {
+		t.Fatalf("noop PUT changed the file: %q", rec.Body)
+	}
+
+	// Noop requests are validated like real ones.
+	mustPut(t, c, "/d/c.txt", "c")
+	if rec := putNoop(c, "/d", "x"); rec.Code != http.StatusBadRequest || rec.Header().Get("X-Restfs-Noop") != "" {
+		t.Fatalf("noop PUT over a directory: %d", rec.Code)
+	}
+	if rec := putNoop(c, "/d/", "x"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("noop PUT to a directory path: %d", rec.Code)
+	}
+}
+
+// gcRemoves returns the gc_remove entries in log as reason:name.
+func gcRemoves(t *testing.T, log string) []string {
+	t.Helper()
+	var removes []string
+	for _, line := range strings.Split(log, "\n") {
+		var entry struct{ Op, Path, Reason string }
+		if json.Unmarshal([]byte(line), &entry) == nil && entry.Op == "gc_remove" {
+			removes = append(removes, entry.Reason+":"+filepath.Base(entry.Path))
+		}
+	}
+	return removes
+}
+
+func collectPath(t *testing.T, fullpath string) error {
+	t.Helper()
+	tpath, tstat, err := findTombstone(fullpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collect(tpath, tstat)
+}
+
+func TestGCRemoveReasons(t *testing.T) {
+	c := newTestFS(t)
+	tomb := filepath.Base(tombstonePath(filepath.Join(c.dir, "a.txt")))
+	tests := []struct {
+		name    string
+		prepare func(fullpath string)
+		want    []string
+	}{
+		{"deleted", func(string) {}, []string{reasonTombstoneNewer + ":a.txt", reasonTombstoneNewer + ":" + tomb}},
+		{"rewritten", func(string) {
+			time.Sleep(10 * time.Millisecond)
+			mustPut(t, c, "/a.txt", "new")
+		}, []string{reasonTombstoneStale + ":" + tomb}},
+		{"missing", func(fullpath string) { os.Remove(fullpath) }, []string{reasonDataFileMissing + ":" + tomb}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fullpath := filepath.Join(c.dir, "a.txt")
+			mustPut(t, c, "/a.txt", "old")
+			do(c, "DELETE", "/a.txt", "")
+			tt.prepare(fullpath)
+			before := make(map[string]float64)
+			for _, reason := range []string{reasonTombstoneNewer, reasonTombstoneStale, reasonDataFileMissing} {
+				before[reason] = counterValue(t, gcRemoved.WithLabelValues(reason))
+			}
+
+			buf := captureLog(t)
+			if err := collectPath(t, fullpath); err != nil {
+				t.Fatal(err)
+			}
+			got := gcRemoves(t, buf.String())
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("gc_remove = %v, want %v", got, tt.want)
+			}
+			removed := make(map[string]float64)
+			for _, r := range tt.want {
+				removed[strings.SplitN(r, ":", 2)[0]]++
+			}
+			for reason, n := range before {
+				if d := counterValue(t, gcRemoved.WithLabelValues(reason)) - n; d != removed[reason] {
+					t.Errorf("%s count +%v, want +%v", reason, d, removed[reason])
+				}
+			}
+			os.Remove(fullpath)
+		})
+	}
+}
+
+func TestGCRemoveFailed(t *testing.T) {
+	c := newTestFS(t)
+	fullpath := filepath.Join(c.dir, "a.txt")
+	mustPut(t, c, "/a.txt", "old")
+	do(c, "DELETE", "/a.txt", "")
+	// A directory with contents in place of the data file cannot be removed.
+	os.Remove(fullpath)
+	os.MkdirAll(filepath.Join(fullpath, "sub"), 0777)
+	tpath, _, _ := findTombstone(fullpath)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(tpath, future, future)
+	n := counterValue(t, gcRemoved.WithLabelValues(reasonTombstoneNewer))
+
+	buf := captureLog(t)
+	if err := collectPath(t, fullpath); err == nil {
+		t.Fatal("removal did not fail")
+	}
+	if got := gcRemoves(t, buf.String()); len(got) != 0 {
+		t.Fatalf("failed removal logged: %v", got)
+	}
+	if counterValue(t, gcRemoved.WithLabelValues(reasonTombstoneNewer)) != n {
+		t.Fatal("failed removal counted")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const metaHeaderPrefix = "X-Restfs-Meta-"
+
+// saveMeta stores the content type and X-Restfs-Meta-* headers of a PUT
+// request. A stale sidecar is removed when the request carries none.
+func saveMeta(fullpath string, h http.Header) error {
+	m := metadata{ContentType: h.Get("Content-Type")}
+	for name := range h {
+		if strings.HasPrefix(name, metaHeaderPrefix) {
+			if m.Headers == nil {
+				m.Headers = make(map[string]string)
+			}
+			m.Headers[name] = h.Get(name)
+		}
+	}
+	if m.ContentType == "" && m.Headers == nil {
+		if err := os.Remove(fullpath + metaSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeSidecar(fullpath, metaSuffix, &m)
+}
+
+func setMetadataHeaders(w http.ResponseWriter, fullpath string, s os.FileInfo) {
+	h := w.Header()
+	h.Set("X-Restfs-Size", strconv.FormatInt(s.Size(), 10))
+	h.Set("X-Restfs-Mtime", s.ModTime().UTC().Format(time.RFC3339Nano))
+
+	var sum checksum
+	if err := readSidecar(fullpath, checksumSuffix, &sum); err == nil {
+		h.Set("X-Restfs-SHA256", sum.SHA256)
+	}
+	var m metadata
+	if err := readSidecar(fullpath, metaSuffix, &m); err == nil {
+		if m.ContentType != "" {
+			h.Set("Content-Type", m.ContentType)
+		}
+		for name, value := range m.Headers {
+			h.Set(name, value)
+		}
+	}
+}
+
+// contentType returns the stored content type of fullpath, falling back to
+// detection by file extension.
+func contentType(fullpath string) string {
+	var m metadata
+	if err := readSidecar(fullpath, metaSuffix, &m); err == nil && m.ContentType != "" {
+		return m.ContentType
+	}
+	if ctype := mime.TypeByExtension(path.Ext(fullpath)); ctype != "" {
+		return ctype
+	}
+	return "application/octet-stream"
+}
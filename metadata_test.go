@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetadataHeaders(t *testing.T) {
+	c := newTestFS(t)
+	body := `{"a":1}`
+	r := newRequest("PUT", "/a.json", body)
+	r.Header.Set("Content-Type", "application/vnd.test+json")
+	r.Header.Set("X-Restfs-Meta-Owner", "alice")
+	r.Header.Set("X-Restfs-Meta-Source", "import")
+	if rec := serve(c, r); rec.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", rec.Code, rec.Body)
+	}
+	fi, err := os.Stat(filepath.Join(c.dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(body))
+
+	rec := do(c, "GET", "/a.json", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Fatalf("GET: %d %q", rec.Code, rec.Body)
+	}
+	for name, want := range map[string]string{
+		"Content-Length":       "7",
+		"Content-Type":         "application/vnd.test+json",
+		"X-Restfs-Size":        "7",
+		"X-Restfs-Mtime":       fi.ModTime().UTC().Format(time.RFC3339Nano),
+		"X-Restfs-Sha256":      hex.EncodeToString(sum[:]),
+		"X-Restfs-Meta-Owner":  "alice",
+		"X-Restfs-Meta-Source": "import",
+	} {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// Overwriting without metadata drops the stored headers.
+	mustPut(t, c, "/a.json", "{}")
+	rec = do(c, "GET", "/a.json", "")
+	if got := rec.Header().Get("X-Restfs-Meta-Owner"); got != "" {
+		t.Errorf("stale X-Restfs-Meta-Owner = %q", got)
+	}
+	if got := rec.Header().Get("X-Restfs-Size"); got != "2" {
+		t.Errorf("X-Restfs-Size after overwrite = %q", got)
+	}
+}
+
+func TestMetadataHeadersNotOnErrors(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/dir/a.txt", "a")
+	for _, p := range []string{"/missing", "/dir/"} {
+		if got := do(c, "GET", p, "").Header().Get("X-Restfs-Size"); got != "" {
+			t.Errorf("GET %s: X-Restfs-Size = %q", p, got)
+		}
+	}
+}
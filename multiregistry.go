@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// multiRegistry registers collectors with several registries at once. The
+// collectors themselves are shared, so every registry gathers the same
+// observations and nothing is counted twice.
+type multiRegistry []prometheus.Registerer
+
+func (m multiRegistry) Register(c prometheus.Collector) error {
+	for i, r := range m {
+		if err := r.Register(c); err != nil {
+			for _, prev := range m[:i] {
+				prev.Unregister(c)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiRegistry) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := m.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (m multiRegistry) Unregister(c prometheus.Collector) bool {
+	ok := true
+	for _, r := range m {
+		if !r.Unregister(c) {
+			ok = false
+		}
+	}
+	return ok
+}
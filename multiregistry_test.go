@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gathered returns the value of each metric family gathered from g.
+func gathered(t *testing.T, g prometheus.Gatherer) map[string]float64 {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()] += m.GetCounter().GetValue()
+		}
+	}
+	return values
+}
+
+func TestMultiRegistry(t *testing.T) {
+	a, b := prometheus.NewRegistry(), prometheus.NewRegistry()
+	regs := multiRegistry{a, b}
+	shared := prometheus.NewCounter(prometheus.CounterOpts{Name: "restfs_test_shared_total", Help: "Test counter."})
+	regs.MustRegister(shared)
+	shared.Add(2)
+
+	own := prometheus.NewCounter(prometheus.CounterOpts{Name: "restfs_test_own_total", Help: "Test counter."})
+	a.MustRegister(own)
+	own.Inc()
+
+	// Each registry reports the shared observations once.
+	ga, gb := gathered(t, a), gathered(t, b)
+	if ga["restfs_test_shared_total"] != 2 || gb["restfs_test_shared_total"] != 2 {
+		t.Fatalf("shared counter = %v and %v, want 2", ga, gb)
+	}
+	if ga["restfs_test_own_total"] != 1 {
+		t.Fatalf("own counter = %v, want 1", ga)
+	}
+	if _, ok := gb["restfs_test_own_total"]; ok {
+		t.Fatal("counter registered with one registry gathered by the other")
+	}
+
+	if !regs.Unregister(shared) {
+		t.Fatal("Unregister = false")
+	}
+	if _, ok := gathered(t, a)["restfs_test_shared_total"]; ok {
+		t.Fatal("unregistered counter still gathered")
+	}
+	if _, ok := gathered(t, b)["restfs_test_shared_total"]; ok {
+		t.Fatal("unregistered counter still gathered")
+	}
+}
+
+func TestMultiRegistryRollback(t *testing.T) {
+	a, b := prometheus.NewRegistry(), prometheus.NewRegistry()
+	opts := prometheus.CounterOpts{Name: "restfs_test_conflict_total", Help: "Test counter."}
+	b.MustRegister(prometheus.NewCounter(opts))
+
+	c := prometheus.NewCounter(opts)
+	if err := (multiRegistry{a, b}).Register(c); err == nil {
+		t.Fatal("conflicting registration succeeded")
+	}
+	// The collector is not left behind in the registries before the failing one.
+	if err := a.Register(c); err != nil {
+		t.Fatalf("collector still registered after rollback: %v", err)
+	}
+}
+
+func TestPrometheusSeparateRegistries(t *testing.T) {
+	c := newTestFS(t)
+	a, b := prometheus.NewRegistry(), prometheus.NewRegistry()
+	withPrometheus(c, multiRegistry{a, b})
+
+	before := counterValue(t, panicsRecovered)
+	panicsRecovered.Inc()
+	for name, reg := range map[string]*prometheus.Registry{"first": a, "second": b} {
+		if got := gathered(t, reg)["restfs_panics_recovered_total"]; got != before+1 {
+			t.Errorf("%s registry: panics recovered = %v, want %v", name, got, before+1)
+		}
+	}
+
+	// The default registry is left alone.
+	if _, ok := gathered(t, prometheus.DefaultGatherer)["restfs_panics_recovered_total"]; ok {
+		t.Error("metrics registered with the default registry")
+	}
+}
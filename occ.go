@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// keyedMutex provides a mutex per key, created on demand and dropped once
+// nobody holds or waits for it.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (m *keyedMutex) lock(key string) (unlock func()) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	l := m.locks[key]
+	if l == nil {
+		l = new(keyedLock)
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+var pathLocks keyedMutex
+
+// etagMatches evaluates an If-Match header against the current file, which
+// is nil if it does not exist. restfs only issues weak ETags, so the weak
+// comparison is used.
+func etagMatches(ifMatch string, s os.FileInfo) bool {
+	if s == nil || s.IsDir() {
+		return false
+	}
+	if strings.TrimSpace(ifMatch) == "*" {
+		return true
+	}
+	current := strings.TrimPrefix(genEtag(s), "W/")
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func putIfMatch(h http.Handler, p, body, etag string) int {
+	r := newRequest("PUT", p, body)
+	r.Header.Set("If-Match", etag)
+	return serve(h, r).Code
+}
+
+func TestPutIfMatch(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "v1")
+	etag := do(c, "GET", "/a.txt", "").Header().Get("Etag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	if code := putIfMatch(c, "/a.txt", "v2", etag); code != http.StatusOK {
+		t.Fatalf("PUT with the current ETag: %d", code)
+	}
+	if code := putIfMatch(c, "/a.txt", "v3", etag); code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with a stale ETag: %d", code)
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Body.String() != "v2" {
+		t.Fatalf("body = %q", rec.Body)
+	}
+
+	for _, ifMatch := range []func(etag string) string{
+		func(etag string) string { return `"other", ` + etag },
+		func(etag string) string { return strings.TrimPrefix(etag, "W/") },
+		func(string) string { return "*" },
+	} {
+		h := ifMatch(do(c, "GET", "/a.txt", "").Header().Get("Etag"))
+		if code := putIfMatch(c, "/a.txt", "v2", h); code != http.StatusOK {
+			t.Errorf("If-Match %s: %d", h, code)
+		}
+	}
+	if code := putIfMatch(c, "/missing.txt", "x", "*"); code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match * on a missing file: %d", code)
+	}
+}
+
+func TestPutIfMatchConcurrent(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/counter", "0")
+	etag := do(c, "GET", "/counter", "").Header().Get("Etag")
+
+	const writers = 20
+	var (
+		wg       sync.WaitGroup
+		won      int32
+		rejected int32
+		start    = make(chan struct{})
+	)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			// Writers of different sizes always change the ETag.
+			switch putIfMatch(c, "/counter", string(make([]byte, i+2)), etag) {
+			case http.StatusOK:
+				atomic.AddInt32(&won, 1)
+			case http.StatusPreconditionFailed:
+				atomic.AddInt32(&rejected, 1)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	if won != 1 || rejected != writers-1 {
+		t.Fatalf("%d writers won and %d were rejected, want 1 and %d", won, rejected, writers-1)
+	}
+}
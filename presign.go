@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	presignSecret  = flag.String("presign-secret", "", "Secret for signing bundle download URLs; empty disables them")
+	presignBaseURL = flag.String("presign-base-url", "", "Public URL of the -listen address used in signed bundle URLs, e.g. https://files.example.com")
+)
+
+const (
+	bundlePath         = "/-/bundle"
+	bundleMaxExpiresIn = 7 * 24 * time.Hour
+)
+
+func init() {
+	registerValidator(func() error {
+		if *presignSecret == "" {
+			return nil
+		}
+		if *presignBaseURL == "" {
+			return errors.New("-presign-secret requires -presign-base-url")
+		}
+		_, err := parsePresignBaseURL()
+		return err
+	})
+	registerEndpoint("/-/admin/presign-bundle", servePresignBundle)
+	registerEndpoint(bundlePath, serveBundle)
+}
+
+func servePresignBundle(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if *presignSecret == "" {
+		http.Error(w, "Pre-signed bundles are disabled; set -presign-secret", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Paths     []string `json:"paths"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 {
+		http.Error(w, "Missing paths", http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) > *batchGetMaxFiles {
+		http.Error(w, fmt.Sprintf("Too many paths; at most %d allowed", *batchGetMaxFiles), http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(req.ExpiresIn)
+	if err != nil || d <= 0 || d > bundleMaxExpiresIn {
+		http.Error(w, fmt.Sprintf("expires_in must be a duration up to %s", bundleMaxExpiresIn), http.StatusBadRequest)
+		return
+	}
+
+	paths := bundlePaths(req.Paths)
+	// Signed downloads skip the authorization callback, so the client must
+	// be allowed to read every path now.
+	for _, p := range paths {
+		if code, msg := authorizePath(r, "GET", p); code != 0 {
+			http.Error(w, p+": "+msg, code)
+			return
+		}
+	}
+	expires := time.Now().Add(d).Unix()
+	q := url.Values{}
+	q["path"] = paths
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("bundle-sig", signBundle(paths, expires))
+	// The request came in on the admin listener, so its host is of no use.
+	u, err := parsePresignBaseURL()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + bundlePath
+	u.RawQuery = q.Encode()
+	writeJSON(w, http.StatusOK, map[string]string{"url": u.String()})
+}
+
+func parsePresignBaseURL() (*url.URL, error) {
+	u, err := url.Parse(*presignBaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid -presign-base-url %q; want an absolute http or https URL", *presignBaseURL)
+	}
+	return u, nil
+}
+
+// bundlePaths cleans and sorts paths so that the signature does not depend
+// on their order.
+func bundlePaths(paths []string) []string {
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = path.Clean("/" + p)
+	}
+	sort.Strings(cleaned)
+	return cleaned
+}
+
+func signBundle(paths []string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(*presignSecret))
+	io.WriteString(mac, strings.Join(paths, "\n")+"\n"+strconv.FormatInt(expires, 10))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bundleRequestValid reports whether r is a bundle download with a valid,
+// unexpired signature. Such requests carry their own authorization.
+func bundleRequestValid(r *http.Request) bool {
+	if *presignSecret == "" || r.URL.Path != bundlePath {
+		return false
+	}
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	sig := signBundle(bundlePaths(q["path"]), expires)
+	return hmac.Equal([]byte(q.Get("bundle-sig")), []byte(sig))
+}
+
+// serveBundle streams the signed paths as a tar.gz archive. All files must
+// still exist when the download starts.
+func serveBundle(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !bundleRequestValid(r) {
+		http.Error(w, "Invalid or expired bundle signature", http.StatusForbidden)
+		return
+	}
+	paths := bundlePaths(r.URL.Query()["path"])
+	for _, p := range paths {
+		fullpath := c.readablePath(p)
+		if s := stat(fullpath); s == nil || s.IsDir() || isReserved(path.Base(fullpath)) {
+			http.Error(w, p+" is no longer available", http.StatusGone)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="bundle.tar.gz"`)
+	w.WriteHeader(http.StatusOK)
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, p := range paths {
+		if err := writeBundleEntry(tw, c.readablePath(p), strings.TrimPrefix(p, "/")); err != nil {
+			log.Printf("Bundle download aborted at %s: %v", p, err)
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Print(err)
+	}
+}
+
+func writeBundleEntry(tw *tar.Writer, fullpath, name string) error {
+	f, err := os.Open(writeBuf.locate(fullpath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, fi.Size())
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// presignBundle asks the admin handler h to sign paths and returns the URL.
+func presignBundle(t *testing.T, h http.Handler, paths ...string) (*url.URL, *http.Response) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"paths": paths, "expires_in": "1h"})
+	r := newRequest("POST", "/-/admin/presign-bundle", string(body))
+	r.Header.Set("Authorization", "token")
+	rec := serve(h, r)
+	if rec.Code != http.StatusOK {
+		return nil, rec.Result()
+	}
+	var res struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, rec.Result()
+}
+
+func TestPresignBundle(t *testing.T) {
+	c := newTestFS(t)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	setFlag(t, "presign-secret", "secret")
+	setFlag(t, "presign-base-url", srv.URL+"/")
+	mustPut(t, c, "/a.txt", "aaa")
+	mustPut(t, c, "/dir/b.txt", "bb")
+
+	u, resp := presignBundle(t, c.adminHandler(), "dir/b.txt", "/a.txt")
+	if u == nil {
+		t.Fatalf("presign: %d", resp.StatusCode)
+	}
+	if want := srv.URL + bundlePath + "?"; !strings.HasPrefix(u.String(), want) {
+		t.Fatalf("url = %s, want %s...", u, want)
+	}
+	// The returned URL is fetched as is from the main listener.
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := http.Get(u.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	resp = get()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("bundle: %d %s", resp.StatusCode, resp.Header)
+	}
+	files := readBundle(t, resp.Body)
+	if len(files) != 2 || files["a.txt"] != "aaa" || files["dir/b.txt"] != "bb" {
+		t.Fatalf("bundle = %q", files)
+	}
+
+	do(c, "DELETE", "/a.txt", "")
+	if resp := get(); resp.StatusCode != http.StatusGone {
+		t.Fatalf("bundle with a deleted file: %d", resp.StatusCode)
+	}
+}
+
+func TestPresignBundleBaseURL(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "presign-secret", "secret")
+	setFlag(t, "presign-base-url", "https://files.example.com/store")
+	mustPut(t, c, "/a.txt", "aaa")
+	u, resp := presignBundle(t, c.adminHandler(), "/a.txt")
+	if u == nil {
+		t.Fatalf("presign: %d", resp.StatusCode)
+	}
+	if u.Scheme != "https" || u.Host != "files.example.com" || u.Path != "/store"+bundlePath {
+		t.Fatalf("url = %s", u)
+	}
+}
+
+func TestBundleSignature(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "presign-secret", "secret")
+	setFlag(t, "presign-base-url", "http://localhost")
+	mustPut(t, c, "/a.txt", "aaa")
+	mustPut(t, c, "/b.txt", "bbb")
+	u, _ := presignBundle(t, c.adminHandler(), "/a.txt")
+	if u == nil {
+		t.Fatal("presign failed")
+	}
+
+	tamper := func(f func(q url.Values)) string {
+		q := u.Query()
+		f(q)
+		return bundlePath + "?" + q.Encode()
+	}
+	for name, uri := range map[string]string{
+		"added path":    tamper(func(q url.Values) { q.Add("path", "/b.txt") }),
+		"replaced path": tamper(func(q url.Values) { q.Set("path", "/b.txt") }),
+		"extended":      tamper(func(q url.Values) { q.Set("expires", q.Get("expires")+"0") }),
+		"expired":       tamper(func(q url.Values) { q.Set("expires", "1") }),
+		"bad signature": tamper(func(q url.Values) { q.Set("bundle-sig", strings.Repeat("0", 64)) }),
+		"no signature":  tamper(func(q url.Values) { q.Del("bundle-sig") }),
+		"no expiry":     tamper(func(q url.Values) { q.Del("expires") }),
+	} {
+		if rec := do(c, "GET", uri, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: %d, want 403", name, rec.Code)
+		}
+	}
+
+	setFlag(t, "presign-secret", "other")
+	if rec := do(c, "GET", u.RequestURI(), ""); rec.Code != http.StatusForbidden {
+		t.Errorf("signed with another secret: %d, want 403", rec.Code)
+	}
+}
+
+func TestPresignBundleAuthorizesPaths(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "presign-secret", "secret")
+	setFlag(t, "presign-base-url", "http://localhost")
+	mustPut(t, c, "/public/a.txt", "public")
+	mustPut(t, c, "/secret/b.txt", "secret")
+	h := withTestAuth(t, c, func(req *authRequest) bool {
+		return req.Method == "GET" && strings.HasPrefix(req.Path, "/public/")
+	})
+
+	if u, resp := presignBundle(t, c.adminHandler(), "/public/a.txt", "/secret/b.txt"); u != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("presign of an unauthorized path: %d", resp.StatusCode)
+	}
+	u, resp := presignBundle(t, c.adminHandler(), "/public/a.txt")
+	if u == nil {
+		t.Fatalf("presign: %d", resp.StatusCode)
+	}
+	// The signed URL works without credentials.
+	rec := do(h, "GET", u.RequestURI(), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bundle: %d %s", rec.Code, rec.Body)
+	}
+	if files := readBundle(t, rec.Body); files["public/a.txt"] != "public" {
+		t.Fatalf("bundle = %q", files)
+	}
+}
+
+func TestPresignBundleDisabled(t *testing.T) {
+	c := newTestFS(t)
+	if _, resp := presignBundle(t, c.adminHandler(), "/a.txt"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("presign without a secret: %d", resp.StatusCode)
+	}
+}
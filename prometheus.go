@@ -5,36 +5,178 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/yosisa/webutil"
 )
 
-var prometheusAddr = flag.String("prometheus", "", "Listen address for prometheus")
+// addrList collects the values of a flag that may be repeated.
+type addrList []string
+
+func (l *addrList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *addrList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var prometheusAddrs addrList
+
+var panicsRecovered = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "restfs",
+	Name:      "panics_recovered_total",
+	Help:      "Total number of panics recovered in HTTP handlers.",
+})
+
+var gcRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "restfs",
+	Subsystem: "gc",
+	Name:      "removed_files_total",
+	Help:      "Total number of files removed by GC.",
+}, []string{"reason"})
+
+var tlsHandshakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "restfs",
+	Name:      "tls_handshakes_total",
+	Help:      "Total number of TLS handshakes by result.",
+}, []string{"result"})
+
+var connections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "restfs",
+	Name:      "connections_total",
+	Help:      "Total number of HTTP connection events by state.",
+}, []string{"state"})
+
+var storedFilesByType = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "restfs",
+	Name:      "stored_files_by_type",
+	Help:      "Estimated number of stored files by content type.",
+}, []string{"content_type"})
+
+var coalescedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "restfs",
+	Name:      "coalesced_requests_total",
+	Help:      "Total number of GET requests that shared a concurrent file lookup.",
+})
+
+var transformDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "restfs",
+	Name:      "transform_duration_seconds",
+	Help:      "Time taken by transform commands by rule pattern.",
+}, []string{"pattern"})
+
+var copyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "restfs",
+	Name:      "copy_duration_seconds",
+	Help:      "Time taken by COPY requests to copy a file by mode.",
+}, []string{"mode"})
+
+var (
+	recursiveCopyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "restfs",
+		Name:      "recursive_copy_duration_seconds",
+		Help:      "Time taken by COPY requests with Depth: infinity.",
+	})
+	recursiveCopyFiles = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "restfs",
+		Name:      "recursive_copy_files",
+		Help:      "Number of files copied by COPY requests with Depth: infinity.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	})
+)
+
+var bufferQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "restfs",
+	Name:      "buffer_queue_depth",
+	Help:      "Number of uploaded files waiting to be flushed from the tmpfs buffer.",
+})
+
+var (
+	dirWriteRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "restfs",
+		Name:      "directory_write_bytes_per_second",
+		Help:      "Smoothed write throughput per top-level directory.",
+	}, []string{"dir"})
+	dirReadRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "restfs",
+		Name:      "directory_read_bytes_per_second",
+		Help:      "Smoothed read throughput per top-level directory.",
+	}, []string{"dir"})
+)
 
 func init() {
+	flag.Var(&prometheusAddrs, "prometheus", "Listen address for prometheus; may be repeated to serve separate registries")
+	registerValidator(func() error {
+		for i, addr := range prometheusAddrs {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("invalid -prometheus address %q: %v", addr, err)
+			}
+			if addrConflicts(addr, *listen) {
+				return fmt.Errorf("-prometheus address %s conflicts with -listen address %s", addr, *listen)
+			}
+			for _, other := range prometheusAddrs[:i] {
+				if addrConflicts(addr, other) {
+					return fmt.Errorf("-prometheus addresses %s and %s conflict", other, addr)
+				}
+			}
+		}
+		return nil
+	})
+	registerInspector(2, "prometheus", func(r *http.Request, res *introspection) bool {
+		return prometheusEnabled()
+	})
 	registerMiddleware(2, func(h http.Handler) http.Handler {
-		if *prometheusAddr == "" {
+		if !prometheusEnabled() {
 			return h
 		}
 
-		log.Printf("Prometheus stats enabled at %s", *prometheusAddr)
-		go listenAndServePrometheusHandler(*prometheusAddr)
-		return withPrometheus(h)
+		if len(prometheusAddrs) <= 1 {
+			for _, addr := range prometheusAddrs {
+				log.Printf("Prometheus stats enabled at %s", addr)
+				go listenAndServePrometheusHandler(addr, prometheus.Handler())
+			}
+			return withPrometheus(h, prometheus.DefaultRegisterer)
+		}
+
+		// Each listener gets its own registry; pushed metrics still come
+		// from the default one.
+		var regs multiRegistry
+		for _, addr := range prometheusAddrs {
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(prometheus.NewGoCollector())
+			reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+			regs = append(regs, reg)
+			log.Printf("Prometheus stats enabled at %s", addr)
+			go listenAndServePrometheusHandler(addr, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		}
+		if *pushgatewayURL != "" {
+			regs = append(regs, prometheus.DefaultRegisterer)
+		}
+		return withPrometheus(h, regs)
 	})
 }
 
-func withPrometheus(h http.Handler) http.Handler {
+// prometheusEnabled reports whether metrics are collected, either to be
+// scraped or pushed.
+func prometheusEnabled() bool {
+	return len(prometheusAddrs) > 0 || *pushgatewayURL != ""
+}
+
+func withPrometheus(h http.Handler, reg prometheus.Registerer) http.Handler {
 	reqCnt := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "restfs",
 		Subsystem: "http",
 		Name:      "requests_total",
 		Help:      "Total number of HTTP requests made.",
-	}, []string{"method", "code"})
+	}, []string{"method", "code", "served_by"})
 
 	opts := prometheus.SummaryOpts{
 		Namespace: "restfs",
@@ -53,10 +195,34 @@ func withPrometheus(h http.Handler) http.Handler {
 	opts.Help = "The HTTP response sizes in bytes."
 	resSz := prometheus.NewSummaryVec(opts, []string{"method"})
 
-	prometheus.MustRegister(reqCnt)
-	prometheus.MustRegister(reqDur)
-	prometheus.MustRegister(reqSz)
-	prometheus.MustRegister(resSz)
+	diskAvail := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "restfs",
+		Subsystem: "disk",
+		Name:      "reserved_available_bytes",
+		Help:      "Free disk space in bytes above the write reserve.",
+	}, func() float64 {
+		return reservedAvailable(dataDirPath())
+	})
+
+	reg.MustRegister(reqCnt)
+	reg.MustRegister(reqDur)
+	reg.MustRegister(reqSz)
+	reg.MustRegister(resSz)
+	reg.MustRegister(diskAvail)
+	reg.MustRegister(panicsRecovered)
+	reg.MustRegister(gcRemoved)
+	reg.MustRegister(tlsHandshakes)
+	reg.MustRegister(connections)
+	reg.MustRegister(storedFilesByType)
+	reg.MustRegister(coalescedRequests)
+	reg.MustRegister(dirWriteRate)
+	reg.MustRegister(dirReadRate)
+	reg.MustRegister(bufferQueueDepth)
+	reg.MustRegister(transformDuration)
+	reg.MustRegister(copyDuration)
+	reg.MustRegister(recursiveCopyDuration)
+	reg.MustRegister(recursiveCopyFiles)
+	bandwidth.start()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		start := time.Now()
@@ -71,6 +237,7 @@ func withPrometheus(h http.Handler) http.Handler {
 				req.Body = body
 			}
 		}
+		req, servedBy := withServedBy(req)
 		lw := webutil.WrapResponseWriter(w)
 		h.ServeHTTP(lw, req)
 
@@ -82,15 +249,15 @@ func withPrometheus(h http.Handler) http.Handler {
 			fmt.Println(reqsz)
 		}
 
-		reqCnt.WithLabelValues(method, status).Inc()
+		reqCnt.WithLabelValues(method, status, *servedBy).Inc()
 		reqDur.WithLabelValues(method).Observe(elapsed)
 		reqSz.WithLabelValues(method).Observe(float64(reqsz))
 		resSz.WithLabelValues(method).Observe(float64(lw.Size))
 	})
 }
 
-func listenAndServePrometheusHandler(addr string) {
-	http.ListenAndServe(addr, prometheus.Handler())
+func listenAndServePrometheusHandler(addr string, h http.Handler) {
+	http.ListenAndServe(addr, h)
 }
 
 type loggedBody struct {
@@ -128,6 +295,8 @@ func lowerMethod(method string) string {
 		return "head"
 	case "OPTIONS", "options":
 		return "options"
+	case "COPY", "copy":
+		return "copy"
 	}
 	return strings.ToLower(method)
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	pushgatewayURL      = flag.String("pushgateway-url", "", "Push metrics to this Prometheus Push Gateway")
+	pushgatewayJob      = flag.String("pushgateway-job", "restfs", "Job name used when pushing metrics")
+	pushgatewayInterval = flag.Duration("pushgateway-interval", 0, "Push metrics at this interval; 0 pushes only at startup and shutdown")
+)
+
+func init() {
+	registerValidator(func() error {
+		if *pushgatewayURL == "" {
+			return nil
+		}
+		u, err := url.Parse(*pushgatewayURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -pushgateway-url %q", *pushgatewayURL)
+		}
+		if *pushgatewayJob == "" {
+			return fmt.Errorf("-pushgateway-job must not be empty")
+		}
+		if *pushgatewayInterval < 0 {
+			return fmt.Errorf("-pushgateway-interval must not be negative: %s", *pushgatewayInterval)
+		}
+		return nil
+	})
+}
+
+func startPushgateway() {
+	if *pushgatewayURL == "" {
+		return
+	}
+	log.Printf("Pushing metrics to %s", *pushgatewayURL)
+	pushMetrics()
+	if *pushgatewayInterval > 0 {
+		go func() {
+			for range time.Tick(*pushgatewayInterval) {
+				pushMetrics()
+			}
+		}()
+	}
+}
+
+func pushMetrics() {
+	if *pushgatewayURL == "" {
+		return
+	}
+	err := push.New(*pushgatewayURL, *pushgatewayJob).
+		Client(&http.Client{Timeout: 10 * time.Second}).
+		Gatherer(prometheus.DefaultGatherer).
+		Push()
+	if err != nil {
+		log.Printf("Failed to push metrics: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+type pushedMetrics struct {
+	method, path string
+	families     map[string]*dto.MetricFamily
+}
+
+// newPushgateway returns a mock Push Gateway that records each push.
+func newPushgateway(t *testing.T) (*httptest.Server, func() []pushedMetrics) {
+	var (
+		mu     sync.Mutex
+		pushes []pushedMetrics
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := pushedMetrics{method: r.Method, path: r.URL.Path, families: make(map[string]*dto.MetricFamily)}
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("decode pushed metrics: %v", err)
+				break
+			}
+			p.families[mf.GetName()] = &mf
+		}
+		mu.Lock()
+		pushes = append(pushes, p)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []pushedMetrics {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]pushedMetrics(nil), pushes...)
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	srv, pushes := newPushgateway(t)
+	setFlag(t, "pushgateway-url", srv.URL)
+	setFlag(t, "pushgateway-job", "restfs-test")
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "restfs_test_pushed_total", Help: "Test counter."})
+	prometheus.MustRegister(c)
+	defer prometheus.Unregister(c)
+	c.Add(3)
+
+	startPushgateway()
+	c.Inc()
+	pushMetrics()
+
+	got := pushes()
+	if len(got) != 2 {
+		t.Fatalf("%d pushes, want one at startup and one at shutdown", len(got))
+	}
+	for i, want := range []float64{3, 4} {
+		p := got[i]
+		if p.method != "PUT" || p.path != "/metrics/job/restfs-test" {
+			t.Errorf("push %d: %s %s", i, p.method, p.path)
+		}
+		mf := p.families["restfs_test_pushed_total"]
+		if mf == nil || len(mf.GetMetric()) != 1 || mf.GetMetric()[0].GetCounter().GetValue() != want {
+			t.Errorf("push %d: restfs_test_pushed_total = %v, want %v", i, mf, want)
+		}
+	}
+}
+
+func TestPushMetricsFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	setFlag(t, "pushgateway-url", srv.URL)
+	buf := captureLog(t)
+	pushMetrics()
+	if !strings.Contains(buf.String(), "Failed to push metrics") {
+		t.Fatal("failed push not logged")
+	}
+}
+
+func TestPushMetricsDisabled(t *testing.T) {
+	_, pushes := newPushgateway(t)
+	startPushgateway()
+	pushMetrics()
+	if n := len(pushes()); n != 0 {
+		t.Fatalf("%d pushes without -pushgateway-url", n)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	gcHeartbeatTimeout = 5 * time.Second
+	gcStallTimeout     = time.Minute
+)
+
+type readiness struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func init() {
+	registerEndpoint("/-/ready", serveReady)
+}
+
+// serveReady reports whether the data directory is writable and the GC
+// goroutine is still alive.
+func serveReady(c *restfs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := ioutil.TempFile(c.dir, tempPrefix+"ready-")
+	if err == nil {
+		f.Close()
+		err = os.Remove(f.Name())
+	}
+	if err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, &readiness{Status: "degraded", Reason: "data_dir_not_writable"})
+		return
+	}
+	if c.gc != nil && !c.gc.alive(gcHeartbeatTimeout) {
+		writeJSON(w, http.StatusServiceUnavailable, &readiness{Status: "degraded", Reason: "gc_goroutine_unresponsive"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &readiness{Status: "ok"})
+}
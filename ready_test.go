@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setGCTimeouts(t *testing.T, heartbeat, stall time.Duration) {
+	oldHeartbeat, oldStall := gcHeartbeatTimeout, gcStallTimeout
+	gcHeartbeatTimeout, gcStallTimeout = heartbeat, stall
+	t.Cleanup(func() { gcHeartbeatTimeout, gcStallTimeout = oldHeartbeat, oldStall })
+}
+
+func TestReady(t *testing.T) {
+	c := newTestFS(t)
+	c.gc = newGC(c.dir, []string{c.dir})
+	defer close(c.gc.invoke)
+	if rec := do(c, "GET", "/-/ready", ""); rec.Code != http.StatusOK {
+		t.Fatalf("ready: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReadyDataDirNotWritable(t *testing.T) {
+	c := newTestFS(t)
+	c.dir = filepath.Join(c.dir, "missing")
+	rec := do(c, "GET", "/-/ready", "")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "data_dir_not_writable") {
+		t.Fatalf("ready: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReadyGCStopped(t *testing.T) {
+	setGCTimeouts(t, 50*time.Millisecond, time.Minute)
+	c := newTestFS(t)
+	c.gc = newGC(c.dir, []string{c.dir})
+	close(c.gc.invoke)
+	// The goroutine may still answer a heartbeat before it sees the close.
+	waitFor(t, func() bool {
+		rec := do(c, "GET", "/-/ready", "")
+		return rec.Code == http.StatusServiceUnavailable && strings.Contains(rec.Body.String(), "gc_goroutine_unresponsive")
+	})
+}
+
+func TestReadyGCStalled(t *testing.T) {
+	setGCTimeouts(t, 50*time.Millisecond, 100*time.Millisecond)
+	c := newTestFS(t)
+	release := make(chan struct{})
+	defer close(release)
+	old := gcHooks
+	registerGCHook(func(dir string) { <-release })
+	t.Cleanup(func() { gcHooks = old })
+
+	c.gc = newGC(c.dir, []string{c.dir})
+	defer close(c.gc.invoke)
+	c.gc.Start()
+	waitFor(t, func() bool { return atomic.LoadInt32(&c.gc.running) == 1 })
+	if rec := do(c, "GET", "/-/ready", ""); rec.Code != http.StatusOK {
+		t.Fatalf("ready while GC progresses: %d %s", rec.Code, rec.Body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if rec := do(c, "GET", "/-/ready", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("ready while GC is stalled: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestGCRecoversPanic(t *testing.T) {
+	setGCTimeouts(t, time.Second, time.Minute)
+	c := newTestFS(t)
+	old := gcHooks
+	registerGCHook(func(dir string) { panic("boom") })
+	t.Cleanup(func() { gcHooks = old })
+	captureLog(t)
+
+	c.gc = newGC(c.dir, []string{c.dir})
+	defer close(c.gc.invoke)
+	before := counterValue(t, panicsRecovered)
+	c.gc.Start()
+	waitFor(t, func() bool { return counterValue(t, panicsRecovered) > before })
+	if atomic.LoadInt32(&c.gc.running) != 0 {
+		t.Fatal("GC still marked running after a panic")
+	}
+	if rec := do(c, "GET", "/-/ready", ""); rec.Code != http.StatusOK {
+		t.Fatalf("ready after a recovered panic: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+func recoverMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logJSON("panic", map[string]interface{}{
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"panic_value": fmt.Sprint(v),
+				"stack_trace": string(debug.Stack()),
+				"request_id":  requestID(r),
+			})
+			panicsRecovered.Inc()
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	buf := captureLog(t)
+	h := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	before := counterValue(t, panicsRecovered)
+
+	r := newRequest("GET", "/a.txt", "")
+	r.Header.Set("X-Request-Id", "req-1")
+	if rec := serve(h, r); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("panic: %d", rec.Code)
+	}
+	if got := counterValue(t, panicsRecovered) - before; got != 1 {
+		t.Fatalf("panics recovered = %v, want 1", got)
+	}
+
+	var entry map[string]interface{}
+	line := strings.TrimSpace(buf.String())
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("log entry %q: %v", line, err)
+	}
+	if entry["op"] != "panic" || entry["panic_value"] != "boom" || entry["request_id"] != "req-1" || entry["path"] != "/a.txt" {
+		t.Fatalf("log entry = %v", entry)
+	}
+	if st, _ := entry["stack_trace"].(string); !strings.Contains(st, "TestRecoverMiddleware") {
+		t.Fatalf("stack trace does not include the handler:\n%s", st)
+	}
+}
+
+func TestRecoverMiddlewareAbort(t *testing.T) {
+	h := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	before := counterValue(t, panicsRecovered)
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", v)
+		}
+		if counterValue(t, panicsRecovered) != before {
+			t.Fatal("aborted handler counted as a panic")
+		}
+	}()
+	do(h, "GET", "/", "")
+}
+
+func TestRecoverMiddlewarePassesThrough(t *testing.T) {
+	h := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if rec := do(h, "GET", "/", ""); rec.Code != http.StatusTeapot {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var startupRepairFlag = flag.Bool("startup-repair", false, "Clean up leftovers of interrupted writes before serving")
+
+const staleTempAge = time.Hour
+
+// startupRepair removes stale temporary files and tombstones data files that
+// were truncated to zero bytes although their checksum sidecar says otherwise.
+func startupRepair(dir string) error {
+	var removedTemps, tombstonedCorrupt int
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), tempPrefix) {
+			if time.Since(fi.ModTime()) < staleTempAge {
+				return nil
+			}
+			log.Printf("Remove stale temporary file %s", name)
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			removedTemps++
+			return nil
+		}
+		if isReserved(fi.Name()) || fi.Size() != 0 {
+			return nil
+		}
+
+		// A sidecar older than the file belongs to a previous version.
+		var sum checksum
+		if err := readSidecar(name, checksumSuffix, &sum); err != nil || sum.Size == 0 {
+			return nil
+		}
+		log.Printf("%s is empty but should have %d bytes; possibly corrupted", name, sum.Size)
+		f, err := os.Create(tombstonePath(name))
+		if err != nil {
+			return err
+		}
+		f.Close()
+		tombstonedCorrupt++
+		return nil
+	})
+
+	level := "info"
+	if tombstonedCorrupt > 0 {
+		level = "warn"
+	}
+	logJSON("startup_repair", map[string]interface{}{
+		"level":              level,
+		"removed_temps":      removedTemps,
+		"tombstoned_corrupt": tombstonedCorrupt,
+	})
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupRepair(t *testing.T) {
+	c := newTestFS(t)
+	logs := captureLog(t)
+	old := time.Now().Add(-2 * time.Hour)
+
+	// A crash after the rename left the data file empty, while its
+	// checksum sidecar was already written.
+	mustPut(t, c, "/crashed.txt", "hello")
+	crashed := filepath.Join(c.dir, "crashed.txt")
+	if err := os.Truncate(crashed, 0); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(crashed, old, old)
+
+	// A file overwritten with an empty body has an older, stale sidecar.
+	mustPut(t, c, "/emptied.txt", "hello")
+	emptied := filepath.Join(c.dir, "emptied.txt")
+	os.Chtimes(emptied+checksumSuffix, old, old)
+	os.WriteFile(emptied, nil, 0666)
+
+	staleTemp := filepath.Join(c.dir, tempPrefix+"1")
+	freshTemp := filepath.Join(c.dir, tempPrefix+"2")
+	os.WriteFile(staleTemp, []byte("partial"), 0666)
+	os.WriteFile(freshTemp, []byte("partial"), 0666)
+	os.Chtimes(staleTemp, old, old)
+
+	if err := startupRepair(c.dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(staleTemp); !os.IsNotExist(err) {
+		t.Error("stale temporary file was not removed")
+	}
+	if _, err := os.Stat(freshTemp); err != nil {
+		t.Error("fresh temporary file was removed")
+	}
+	if rec := do(c, "GET", "/crashed.txt", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET crashed.txt = %d, want 404", rec.Code)
+	}
+	if rec := do(c, "GET", "/emptied.txt", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET emptied.txt = %d, want 200", rec.Code)
+	}
+	out := logs.String()
+	for _, want := range []string{`"removed_temps":1`, `"tombstoned_corrupt":1`, `"level":"warn"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %s does not contain %s", out, want)
+		}
+	}
+}
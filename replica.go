@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var readEndpoint = flag.String("read-endpoint", "", "Proxy GET and HEAD requests to this replica URL")
+
+const replicaHealthInterval = 5 * time.Second
+
+type servedByContextKey struct{}
+
+func init() {
+	registerValidator(func() error {
+		if *readEndpoint == "" {
+			return nil
+		}
+		u, err := url.Parse(*readEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -read-endpoint %q", *readEndpoint)
+		}
+		return nil
+	})
+	registerInspector(25, "replica", func(r *http.Request, res *introspection) bool {
+		if *readEndpoint == "" {
+			return false
+		}
+		if (r.Method == "GET" || r.Method == "HEAD") && !strings.HasPrefix(r.URL.Path, "/-/") {
+			res.ServedBy = "replica"
+		}
+		return true
+	})
+	registerMiddleware(25, func(h http.Handler) http.Handler {
+		if *readEndpoint == "" {
+			return h
+		}
+
+		log.Printf("Reads are served by %s", *readEndpoint)
+		u, _ := url.Parse(*readEndpoint)
+		return withReplica(h, u)
+	})
+}
+
+// withReplica proxies reads to the replica at u while it passes health
+// checks. Writes and internal endpoints are always served locally.
+func withReplica(h http.Handler, u *url.URL) http.Handler {
+	var healthy int32
+	go checkReplica(u, &healthy)
+
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Replica request failed, serving locally: %v", err)
+		atomic.StoreInt32(&healthy, 0)
+		setServedBy(r, "local")
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != "GET" && r.Method != "HEAD") || strings.HasPrefix(r.URL.Path, "/-/") || atomic.LoadInt32(&healthy) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		setServedBy(r, "replica")
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func checkReplica(u *url.URL, healthy *int32) {
+	client := &http.Client{Timeout: replicaHealthInterval}
+	for {
+		var ok int32
+		resp, err := client.Head(u.String())
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				ok = 1
+			}
+		}
+		if prev := atomic.SwapInt32(healthy, ok); prev != ok {
+			log.Printf("Replica %s healthy: %t", u, ok == 1)
+		}
+		time.Sleep(replicaHealthInterval)
+	}
+}
+
+// setServedBy records which side served r for the request metrics.
+func setServedBy(r *http.Request, by string) {
+	if p, ok := r.Context().Value(servedByContextKey{}).(*string); ok {
+		*p = by
+	}
+}
+
+func withServedBy(r *http.Request) (*http.Request, *string) {
+	servedBy := "local"
+	return r.WithContext(context.WithValue(r.Context(), servedByContextKey{}, &servedBy)), &servedBy
+}
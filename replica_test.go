@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestReplicaReads(t *testing.T) {
+	captureLog(t)
+	var (
+		mu   sync.Mutex
+		seen []*http.Request
+	)
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r)
+		mu.Unlock()
+		w.Write([]byte("from replica"))
+	}))
+	defer replica.Close()
+	u, _ := url.Parse(replica.URL)
+
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "from primary")
+	var servedBy string
+	h := withReplica(c, u)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, by := withServedBy(r)
+		h.ServeHTTP(w, r)
+		servedBy = *by
+	}))
+	defer primary.Close()
+
+	get := func(p string, hdr map[string]string) (string, string) {
+		t.Helper()
+		r, _ := http.NewRequest("GET", primary.URL+p, nil)
+		for k, v := range hdr {
+			r.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b), servedBy
+	}
+
+	// The first health check runs when the proxy is created.
+	waitFor(t, func() bool {
+		body, _ := get("/a.txt", nil)
+		return body == "from replica"
+	})
+	body, by := get("/a.txt", map[string]string{"Authorization": "Bearer x", "X-Request-Id": "req-1", "Range": "bytes=0-3"})
+	if body != "from replica" || by != "replica" {
+		t.Fatalf("GET: %q served by %s", body, by)
+	}
+	mu.Lock()
+	last := seen[len(seen)-1]
+	mu.Unlock()
+	if last.URL.Path != "/a.txt" || last.Header.Get("Authorization") != "Bearer x" || last.Header.Get("X-Request-Id") != "req-1" || last.Header.Get("Range") != "bytes=0-3" {
+		t.Fatalf("replica got %s %v", last.URL, last.Header)
+	}
+
+	// Writes and internal endpoints stay local.
+	r, _ := http.NewRequest("PUT", primary.URL+"/b.txt", nil)
+	if resp, err := http.DefaultClient.Do(r); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT: %v %v", resp, err)
+	}
+	if servedBy != "local" {
+		t.Fatalf("PUT served by %s", servedBy)
+	}
+	if _, by := get("/-/ready", nil); by != "local" {
+		t.Fatalf("internal endpoint served by %s", by)
+	}
+
+	// Reads fall back to local files when the replica goes away.
+	replica.Close()
+	body, by = get("/a.txt", nil)
+	if body != "from primary" || by != "local" {
+		t.Fatalf("GET with the replica down: %q served by %s", body, by)
+	}
+}
+
+func TestReplicaUnhealthy(t *testing.T) {
+	captureLog(t)
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer replica.Close()
+	u, _ := url.Parse(replica.URL)
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "local")
+	h := withReplica(c, u)
+	if rec := do(h, "GET", "/a.txt", ""); rec.Body.String() != "local" {
+		t.Fatalf("GET with an unhealthy replica: %q", rec.Body)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"syscall"
+)
+
+var reserveBytes = flag.Int64("reserve-bytes", 1<<30, "Reject writes when free disk space falls below this many bytes")
+
+func availBytes(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// hasDiskSpace reports whether writing size more bytes under dir keeps the
+// free space above the reserve. A negative size means unknown. Writes are
+// allowed when free space cannot be determined, e.g. before the data
+// directory is created.
+func hasDiskSpace(dir string, size int64) bool {
+	if *reserveBytes <= 0 {
+		return true
+	}
+	avail, err := availBytes(dir)
+	if err != nil {
+		return true
+	}
+	if size < 0 {
+		size = 0
+	}
+	return avail-size >= *reserveBytes
+}
+
+// reservedAvailable returns the free space above the reserve for the disk
+// metric.
+func reservedAvailable(dir string) float64 {
+	avail, err := availBytes(dir)
+	if err != nil {
+		return math.NaN()
+	}
+	return float64(avail - *reserveBytes)
+}
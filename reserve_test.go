@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestHasDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	avail, err := availBytes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, "reserve-bytes", "0")
+	if !hasDiskSpace(dir, 1<<62) {
+		t.Error("a zero reserve must not reject writes")
+	}
+
+	setFlag(t, "reserve-bytes", "1")
+	if !hasDiskSpace(dir, 0) {
+		t.Error("write rejected with free space above the reserve")
+	}
+	if hasDiskSpace(dir, avail) {
+		t.Error("write consuming all free space accepted")
+	}
+	if !hasDiskSpace(filepath.Join(dir, "missing"), 0) {
+		t.Error("write rejected when free space is unknown")
+	}
+}
+
+func TestReservedAvailableBeforeAnyWrite(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "reserve-bytes", "1024")
+	v := reservedAvailable(dir)
+	if math.IsNaN(v) || v <= -1024 {
+		t.Fatalf("gauge should report statfs before the first write, got %v", v)
+	}
+	if v := reservedAvailable(filepath.Join(dir, "missing")); !math.IsNaN(v) {
+		t.Fatalf("want NaN for a missing directory, got %v", v)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var s3Compat = flag.Bool("s3-compat", false, "Return S3-compatible XML error responses")
+
+var s3ErrorCodes = map[int]string{
+	http.StatusForbidden:           "AccessDenied",
+	http.StatusNotFound:            "NoSuchKey",
+	http.StatusConflict:            "BucketAlreadyExists",
+	http.StatusPreconditionFailed:  "PreconditionFailed",
+	http.StatusInternalServerError: "InternalError",
+}
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty"`
+}
+
+func init() {
+	registerInspector(3, "s3", func(r *http.Request, res *introspection) bool {
+		return *s3Compat
+	})
+	registerMiddleware(3, func(h http.Handler) http.Handler {
+		if !*s3Compat {
+			return h
+		}
+
+		log.Print("S3-compatible error responses enabled")
+		return withS3Errors(h)
+	})
+}
+
+func withS3Errors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &s3ErrorWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			return
+		}
+
+		msg := strings.TrimSpace(sw.body.String())
+		if msg == "" {
+			msg = http.StatusText(sw.code)
+		}
+		w.WriteHeader(sw.code)
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(&s3Error{
+			Code:      s3ErrorCodes[sw.code],
+			Message:   msg,
+			Resource:  r.URL.Path,
+			RequestID: requestID(r),
+		})
+	})
+}
+
+// s3ErrorWriter captures plain text error responses so that they can be
+// rewritten as S3 XML errors once the handler returns.
+type s3ErrorWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *s3ErrorWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	ctype := w.Header().Get("Content-Type")
+	if _, ok := s3ErrorCodes[code]; ok && (ctype == "" || strings.HasPrefix(ctype, "text/plain")) {
+		w.code = code
+		w.Header().Set("Content-Type", "application/xml")
+		w.Header().Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *s3ErrorWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.code != 0 {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush forwards to the underlying writer so that streamed responses are not
+// held back. Captured error bodies are only written when the handler returns.
+func (w *s3ErrorWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.code != 0 {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func requestID(r *http.Request) string {
+	return r.Header.Get("X-Request-Id")
+}
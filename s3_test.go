@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestS3ErrorsRewritePlainTextErrors(t *testing.T) {
+	for _, tt := range []struct {
+		code     int
+		msg      string
+		wantCode string
+		wantMsg  string
+	}{
+		{http.StatusForbidden, "denied", "AccessDenied", "denied"},
+		{http.StatusNotFound, "no such file", "NoSuchKey", "no such file"},
+		{http.StatusConflict, "exists", "BucketAlreadyExists", "exists"},
+		{http.StatusPreconditionFailed, "", "PreconditionFailed", "Precondition Failed"},
+		{http.StatusInternalServerError, "disk <broken> & gone", "InternalError", "disk <broken> & gone"},
+	} {
+		t.Run(tt.wantCode, func(t *testing.T) {
+			h := withS3Errors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.msg == "" {
+					w.WriteHeader(tt.code)
+					return
+				}
+				http.Error(w, tt.msg, tt.code)
+			}))
+			req := httptest.NewRequest("GET", "/a/b", nil)
+			req.Header.Set("X-Request-Id", "req-1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/xml" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+			if !strings.HasPrefix(rec.Body.String(), xml.Header) {
+				t.Fatalf("body %q has no XML header", rec.Body)
+			}
+			var e s3Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+				t.Fatal(err)
+			}
+			want := s3Error{XMLName: xml.Name{Local: "Error"}, Code: tt.wantCode, Message: tt.wantMsg, Resource: "/a/b", RequestID: "req-1"}
+			if e != want {
+				t.Fatalf("error = %+v, want %+v", e, want)
+			}
+		})
+	}
+}
+
+func TestS3ErrorsPassThrough(t *testing.T) {
+	for name, fn := range map[string]http.HandlerFunc{
+		"unmapped status": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad", http.StatusBadRequest)
+		},
+		"json error": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("bad"))
+		},
+	} {
+		rec := httptest.NewRecorder()
+		withS3Errors(fn).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if strings.TrimSpace(rec.Body.String()) != "bad" {
+			t.Errorf("%s: body = %q", name, rec.Body)
+		}
+	}
+}
+
+func TestS3ErrorsForwardFlush(t *testing.T) {
+	h := withS3Errors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped writer does not implement http.Flusher")
+		}
+		w.Write([]byte("partial"))
+		f.Flush()
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Fatal("Flush was not forwarded")
+	}
+	if rec.Body.String() != "partial" {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+}
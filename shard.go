@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shardFlag parses -shard-dirs. Its base directory takes the place of
+// -data-dir; see dataDirPath.
+type shardFlag struct {
+	count int
+	base  string
+}
+
+func (f *shardFlag) String() string {
+	if f.count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", f.count, f.base)
+}
+
+func (f *shardFlag) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return errors.New("must be <shard-count>:<base-dir>")
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid shard count %q", s[:i])
+	}
+	f.count, f.base = n, s[i+1:]
+	return nil
+}
+
+var shards shardFlag
+
+func init() {
+	flag.Var(&shards, "shard-dirs", "Spread files over shards as <shard-count>:<base-dir>")
+	registerValidator(func() error {
+		if shards.count == 0 {
+			return nil
+		}
+		if *dataDir != flag.Lookup("data-dir").DefValue && *dataDir != shards.base {
+			return errors.New("-data-dir conflicts with -shard-dirs")
+		}
+		return nil
+	})
+}
+
+// dataDirPath returns the data directory, which is the base directory of
+// -shard-dirs when sharding.
+func dataDirPath() string {
+	if shards.count > 0 {
+		return shards.base
+	}
+	return *dataDir
+}
+
+func shardName(i int) string {
+	return "shard-" + strconv.Itoa(i)
+}
+
+// shardOf returns the shard storing the cleaned URL path p.
+func shardOf(p string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(p))
+	return int(h.Sum32() % uint32(count))
+}
+
+// dirpaths returns the existing directories for the URL path p. Without
+// sharding, there is at most one.
+func (c *restfs) dirpaths(p string) []string {
+	p = path.Clean("/" + p)
+	candidates := []string{path.Join(c.dir, p)}
+	if c.shards > 0 {
+		candidates = candidates[:0]
+		for i := 0; i < c.shards; i++ {
+			candidates = append(candidates, path.Join(c.dir, shardName(i), p))
+		}
+	}
+	var dirs []string
+	for _, dir := range candidates {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// shardDirs returns the directory of each shard, or just the data directory
+// without sharding.
+func (c *restfs) shardDirs() []string {
+	if c.shards == 0 {
+		return []string{c.dir}
+	}
+	dirs := make([]string, c.shards)
+	for i := range dirs {
+		dirs[i] = filepath.Join(c.dir, shardName(i))
+	}
+	return dirs
+}
+
+// fileAncestor returns the URL path of a file that is an ancestor of the URL
+// path p, if any. Without sharding, the filesystem refuses to create a
+// directory below a file, but a sharded file and a directory at the same
+// path live in different shards.
+func (c *restfs) fileAncestor(p string) string {
+	if c.shards == 0 {
+		return ""
+	}
+	for dir := path.Dir(path.Clean("/" + p)); dir != "/"; dir = path.Dir(dir) {
+		if s := stat(c.fullpath(dir)); s != nil && !s.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// urlpath converts a path in the data directory back to its URL path.
+func (c *restfs) urlpath(fullpath string) (string, error) {
+	rel, err := filepath.Rel(path.Clean(c.dir), fullpath)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if c.shards > 0 {
+		if i := strings.Index(rel, "/"); i >= 0 {
+			rel = rel[i+1:]
+		} else {
+			rel = ""
+		}
+	}
+	return "/" + rel, nil
+}
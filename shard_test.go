@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newShardedFS(t *testing.T, n int) *restfs {
+	c := newTestFS(t)
+	c.shards = n
+	return c
+}
+
+// splitPaths returns a path p and a path below it that are stored in
+// different shards.
+func splitPaths(t *testing.T, c *restfs) (string, string) {
+	for i := 0; i < 100; i++ {
+		p := fmt.Sprintf("/p%d", i)
+		if shardOf(p, c.shards) != shardOf(p+"/f", c.shards) {
+			return p, p + "/f"
+		}
+	}
+	t.Fatal("no paths in different shards")
+	return "", ""
+}
+
+func TestShardOfIsConsistent(t *testing.T) {
+	for _, p := range []string{"/a", "/a/b.txt", "/x/y/z"} {
+		n := shardOf(p, 4)
+		for i := 0; i < 10; i++ {
+			if shardOf(p, 4) != n {
+				t.Fatalf("shard of %s changed", p)
+			}
+		}
+		c := &restfs{dir: "/data", shards: 4}
+		if want := filepath.Join("/data", shardName(n), p); c.fullpath(p) != want {
+			t.Fatalf("fullpath(%s) = %s, want %s", p, c.fullpath(p), want)
+		}
+	}
+}
+
+func TestShardedListingIsMerged(t *testing.T) {
+	c := newShardedFS(t, 4)
+	var want []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("f%d", i)
+		mustPut(t, c, "/dir/"+name, name)
+		want = append(want, name)
+	}
+	mustPut(t, c, "/dir/sub/x", "x")
+	want = append(want, "sub/")
+
+	rec := do(c, "GET", "/dir/", "")
+	names := strings.Fields(rec.Body.String())
+	sort.Strings(want)
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("listing = %v, want %v", names, want)
+	}
+	if rec := do(c, "GET", "/dir/f3", ""); rec.Body.String() != "f3" {
+		t.Fatalf("GET f3 = %q", rec.Body)
+	}
+}
+
+func TestShardedFileDirectoryConflicts(t *testing.T) {
+	c := newShardedFS(t, 4)
+	dir, below := splitPaths(t, c)
+
+	// A directory in one shard cannot be overwritten by a file in another.
+	mustPut(t, c, below, "x")
+	if rec := do(c, "PUT", dir, "file"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("PUT over directory = %d", rec.Code)
+	}
+
+	// Nor can a file get a directory below it.
+	c = newShardedFS(t, 4)
+	mustPut(t, c, dir, "file")
+	if rec := do(c, "PUT", below, "x"); rec.Code != http.StatusConflict {
+		t.Fatalf("PUT below file = %d", rec.Code)
+	}
+	req := newRequest("PUT", below, "")
+	req.Header.Set("X-Restfs-Dir-Only", "true")
+	if rec := serve(c, req); rec.Code != http.StatusConflict {
+		t.Fatalf("mkdir below file = %d", rec.Code)
+	}
+
+	// A deleted file no longer conflicts.
+	do(c, "DELETE", dir, "")
+	mustPut(t, c, below, "x")
+}
+
+func TestShardedGC(t *testing.T) {
+	c := newShardedFS(t, 4)
+	var paths []string
+	for i := 0; i < 8; i++ {
+		p := fmt.Sprintf("/f%d", i)
+		mustPut(t, c, p, "x")
+		do(c, "DELETE", p, "")
+		paths = append(paths, p)
+	}
+	g := &gc{dir: c.dir, roots: c.shardDirs()}
+	var found int64
+	for _, root := range g.roots {
+		n, err := g.collectAll(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found += n
+	}
+	if found != 8 {
+		t.Fatalf("found %d tombstones, want 8", found)
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(c.fullpath(p)); !os.IsNotExist(err) {
+			t.Errorf("%s was not collected", p)
+		}
+	}
+}
+
+func TestShardValidatorHasNoSideEffects(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "base")
+	setFlag(t, "data-dir", dir)
+	old := shards
+	t.Cleanup(func() { shards = old })
+	setFlag(t, "shard-dirs", "2:"+dir)
+	if errs := validateConfig(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("validation created the shard directories")
+	}
+}
+
+func TestShardDirsDataDir(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "base")
+	for _, tt := range []struct {
+		name, dataDir, want string
+	}{
+		{"default data dir", "./data", ""},
+		{"same data dir", base, ""},
+		{"other data dir", t.TempDir(), "-data-dir conflicts with -shard-dirs"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			old := shards
+			t.Cleanup(func() { shards = old })
+			setFlag(t, "data-dir", tt.dataDir)
+			setFlag(t, "shard-dirs", "2:"+base)
+			if errs := configErrors(); errs != tt.want {
+				t.Fatalf("errors = %q, want %q", errs, tt.want)
+			}
+			if tt.want == "" && dataDirPath() != base {
+				t.Fatalf("data dir = %s, want %s", dataDirPath(), base)
+			}
+			if *dataDir != tt.dataDir {
+				t.Fatalf("-data-dir changed to %s", *dataDir)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	checksumSuffix = ".restfs-sha256"
+	metaSuffix     = ".restfs-meta"
+	versionFile    = ".restfs-version"
+)
+
+var sidecarSuffixes = []string{checksumSuffix, metaSuffix}
+
+type checksum struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type metadata struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+func isSidecar(name string) bool {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReserved reports whether name is used internally by restfs and must not
+// be exposed or written by clients.
+func isReserved(name string) bool {
+	return name == versionFile || isTombstone(name) || strings.HasPrefix(name, tempPrefix) || isSidecar(name)
+}
+
+func writeSidecar(fullpath, suffix string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fullpath+suffix, b, 0666)
+}
+
+// readSidecar decodes the sidecar of fullpath into v. A sidecar older than
+// the data file is considered stale and reported as not existing.
+func readSidecar(fullpath, suffix string, v interface{}) error {
+	sstat, err := os.Stat(fullpath + suffix)
+	if err != nil {
+		return err
+	}
+	fstat, err := os.Stat(writeBuf.locate(fullpath))
+	if err != nil {
+		return err
+	}
+	if fstat.ModTime().After(sstat.ModTime()) {
+		return os.ErrNotExist
+	}
+	b, err := ioutil.ReadFile(fullpath + suffix)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func removeSidecars(fullpath string) error {
+	for _, suffix := range sidecarSuffixes {
+		if err := os.Remove(fullpath + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemdListenFD is the first file descriptor passed by systemd socket
+// activation. Tests point it at a socket of their own.
+var systemdListenFD uintptr = 3
+
+// systemdListener returns the socket passed by systemd, or nil when the
+// process was not socket activated.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(systemdListenFD, "socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// keepAliveListener enables TCP keep-alives on accepted connections. graceful
+// only does so for listeners it creates itself.
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(l.period)
+	}
+	return c, nil
+}
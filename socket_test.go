@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// inheritListener passes a new listening socket to systemdListener as if
+// systemd had opened it.
+func inheritListener(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// systemdListener takes ownership of the descriptor.
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := systemdListenFD
+	systemdListenFD = uintptr(fd)
+	t.Cleanup(func() { systemdListenFD = old })
+	return l.Addr().String()
+}
+
+func TestSystemdListener(t *testing.T) {
+	addr := inheritListener(t)
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	l, err := systemdListener()
+	if err != nil || l == nil {
+		t.Fatalf("systemdListener = %v, %v", l, err)
+	}
+	defer l.Close()
+	if l.Addr().String() != addr {
+		t.Fatalf("listening on %s, want %s", l.Addr(), addr)
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS"} {
+		if v, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s=%s left in the environment", name, v)
+		}
+	}
+
+	go func() {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := keepAliveListener{Listener: l, period: time.Minute}.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keepAlive int
+	raw.Control(func(fd uintptr) {
+		keepAlive, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	})
+	if err != nil || keepAlive == 0 {
+		t.Fatalf("SO_KEEPALIVE = %d, %v", keepAlive, err)
+	}
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	for _, env := range []struct{ pid, fds string }{
+		{"", ""},
+		{strconv.Itoa(os.Getpid() + 1), "1"},
+		{strconv.Itoa(os.Getpid()), "0"},
+	} {
+		t.Setenv("LISTEN_PID", env.pid)
+		t.Setenv("LISTEN_FDS", env.fds)
+		if l, err := systemdListener(); l != nil || err != nil {
+			t.Errorf("LISTEN_PID=%q LISTEN_FDS=%q: %v, %v", env.pid, env.fds, l, err)
+		}
+	}
+}
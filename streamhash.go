@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+)
+
+const streamHashTrailer = "X-Content-SHA256"
+
+// serveFileWithHash serves fullpath and sends the SHA-256 of the bytes
+// actually written as a trailer.
+func serveFileWithHash(w http.ResponseWriter, r *http.Request, fullpath, algo string) {
+	if algo != "sha256" {
+		http.Error(w, "Unsupported stream-hash algorithm: "+algo, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Trailer", streamHashTrailer)
+	hw := &hashingWriter{ResponseWriter: w, hash: sha256.New()}
+	http.ServeFile(hw, r, fullpath)
+	w.Header().Set(streamHashTrailer, hex.EncodeToString(hw.hash.Sum(nil)))
+}
+
+type hashingWriter struct {
+	http.ResponseWriter
+	hash hash.Hash
+}
+
+// WriteHeader drops Content-Length so that HTTP/1.1 responses are chunked,
+// which is required to send trailers.
+func (w *hashingWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
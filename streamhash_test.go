@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamHash(t *testing.T) {
+	c := newTestFS(t)
+	body := strings.Repeat("restfs ", 10000)
+	mustPut(t, c, "/a.txt", body)
+	sum := sha256.Sum256([]byte(body))
+	want := hex.EncodeToString(sum[:])
+
+	for _, proto := range []string{"HTTP/1.1", "HTTP/2.0"} {
+		srv := httptest.NewUnstartedServer(c)
+		srv.EnableHTTP2 = proto == "HTTP/2.0"
+		srv.StartTLS()
+		defer srv.Close()
+
+		resp, err := srv.Client().Get(srv.URL + "/a.txt?stream-hash=sha256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Proto != proto {
+			t.Fatalf("proto = %s, want %s", resp.Proto, proto)
+		}
+		if string(b) != body {
+			t.Fatalf("%s: body differs", proto)
+		}
+		if got := resp.Trailer.Get(streamHashTrailer); got != want {
+			t.Fatalf("%s: trailer = %q, want %q", proto, got, want)
+		}
+	}
+}
+
+func TestStreamHashUnsupported(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "a")
+	if rec := do(c, "GET", "/a.txt?stream-hash=md5", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("md5: %d", rec.Code)
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Header().Get("Trailer") != "" {
+		t.Fatal("trailer declared without stream-hash")
+	}
+}
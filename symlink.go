@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	symlinkFollow   = "follow"
+	symlinkPreserve = "preserve"
+
+	symlinkTargetHeader = "X-Restfs-Symlink-Target"
+)
+
+var (
+	symlinkPolicy   = flag.String("symlink-policy", symlinkFollow, "How symlinks are handled: follow or preserve")
+	symlinkMaxDepth = flag.Int("symlink-max-depth", 8, "Maximum number of symlinks followed when resolving a path")
+)
+
+var errSymlinkDepth = errors.New("symlink depth limit exceeded")
+
+func init() {
+	registerValidator(func() error {
+		switch *symlinkPolicy {
+		case symlinkFollow, symlinkPreserve:
+			return nil
+		}
+		return fmt.Errorf("invalid -symlink-policy %q; must be follow or preserve", *symlinkPolicy)
+	})
+}
+
+// entryStat returns the FileInfo of the directory entry at fullpath. Under
+// the preserve policy, symlinks are not followed.
+func entryStat(fullpath string) (os.FileInfo, error) {
+	if *symlinkPolicy == symlinkPreserve {
+		return os.Lstat(fullpath)
+	}
+	return os.Stat(fullpath)
+}
+
+// safeStatFollow resolves symlinks at fullpath one hop at a time and gives up
+// with errSymlinkDepth after maxDepth hops.
+func safeStatFollow(fullpath string, maxDepth int) (os.FileInfo, error) {
+	for depth := 0; ; depth++ {
+		fi, err := os.Lstat(fullpath)
+		if err != nil {
+			if errors.Is(err, syscall.ELOOP) {
+				return nil, errSymlinkDepth
+			}
+			return nil, err
+		}
+		if !isSymlink(fi) {
+			return fi, nil
+		}
+		if depth >= maxDepth {
+			return nil, errSymlinkDepth
+		}
+		target, err := os.Readlink(fullpath)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(fullpath), target)
+		}
+		fullpath = target
+	}
+}
+
+func isSymlink(fi os.FileInfo) bool {
+	return fi.Mode()&os.ModeSymlink != 0
+}
+
+func (c *restfs) serveSymlinkPut(w http.ResponseWriter, r *http.Request, fullpath, target string) {
+	if *symlinkPolicy != symlinkPreserve {
+		http.Error(w, "Symlinks require -symlink-policy=preserve", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength != 0 {
+		http.Error(w, "Symlink requests must not have a body", http.StatusBadRequest)
+		return
+	}
+	targetpath := c.fullpath(target)
+	if targetpath == fullpath || path.Clean("/"+target) == "/" || isReserved(path.Base(targetpath)) {
+		http.Error(w, "Invalid symlink target", http.StatusBadRequest)
+		return
+	}
+	if err := c.saveSymlink(fullpath, targetpath); err != nil {
+		code, msg := classifyFSError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	changes.publish(fullpath)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *restfs) saveSymlink(fullpath, targetpath string) error {
+	dir, _ := path.Split(fullpath)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, targetpath)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return errors.New("symlink target must not be its own directory")
+	}
+	tmp := tempPath(dir)
+	if err := os.Symlink(rel, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fullpath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return removeSidecars(fullpath)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func putSymlink(h http.Handler, p, target string) int {
+	r := newRequest("PUT", p, "")
+	r.Header.Set(symlinkTargetHeader, target)
+	return serve(h, r).Code
+}
+
+func TestSymlink(t *testing.T) {
+	setFlag(t, "symlink-policy", "preserve")
+	c := newTestFS(t)
+	mustPut(t, c, "/releases/v1.txt", "version 1")
+
+	if code := putSymlink(c, "/current.txt", "/releases/v1.txt"); code != http.StatusOK {
+		t.Fatalf("create symlink: %d", code)
+	}
+	target, err := os.Readlink(filepath.Join(c.dir, "current.txt"))
+	if err != nil || target != "releases/v1.txt" {
+		t.Fatalf("link = %q, %v", target, err)
+	}
+	if rec := do(c, "GET", "/current.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "version 1" {
+		t.Fatalf("GET symlink: %d %q", rec.Code, rec.Body)
+	}
+	if rec := do(c, "GET", "/", ""); !strings.Contains(rec.Body.String(), "current.txt@\n") {
+		t.Fatalf("listing = %q", rec.Body)
+	}
+
+	// Retargeting replaces the link.
+	mustPut(t, c, "/releases/v2.txt", "version 2")
+	if code := putSymlink(c, "/current.txt", "/releases/v2.txt"); code != http.StatusOK {
+		t.Fatalf("retarget symlink: %d", code)
+	}
+	if rec := do(c, "GET", "/current.txt", ""); rec.Body.String() != "version 2" {
+		t.Fatalf("GET retargeted symlink: %q", rec.Body)
+	}
+
+	// Deleting the link leaves the target alone.
+	if rec := do(c, "DELETE", "/current.txt", ""); rec.Code >= 300 {
+		t.Fatalf("DELETE symlink: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(c, "GET", "/current.txt", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted symlink: %d", rec.Code)
+	}
+	if rec := do(c, "GET", "/releases/v2.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "version 2" {
+		t.Fatalf("GET target after deleting the link: %d %q", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(tombstonePath(filepath.Join(c.dir, "releases/v2.txt"))); !os.IsNotExist(err) {
+		t.Fatalf("target tombstoned: %v", err)
+	}
+}
+
+func TestSymlinkRejected(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "a")
+	mustPut(t, c, "/dir/b.txt", "b")
+	if code := putSymlink(c, "/link", "/a.txt"); code != http.StatusBadRequest {
+		t.Errorf("symlink with the follow policy: %d", code)
+	}
+
+	setFlag(t, "symlink-policy", "preserve")
+	r := newRequest("PUT", "/link", "body")
+	r.Header.Set(symlinkTargetHeader, "/a.txt")
+	if code := serve(c, r).Code; code != http.StatusBadRequest {
+		t.Errorf("symlink with a body: %d", code)
+	}
+	for _, target := range []string{"/link", "/", "/a.txt" + checksumSuffix} {
+		if code := putSymlink(c, "/link", target); code != http.StatusBadRequest {
+			t.Errorf("symlink to %s: %d", target, code)
+		}
+	}
+	if code := putSymlink(c, "/dir", "/a.txt"); code != http.StatusBadRequest {
+		t.Errorf("symlink over a directory: %d", code)
+	}
+
+	// Targets outside the data directory are confined to it.
+	if code := putSymlink(c, "/escape", "/../../etc/passwd"); code != http.StatusOK {
+		t.Fatalf("symlink to an outside path: %d", code)
+	}
+	target, _ := os.Readlink(filepath.Join(c.dir, "escape"))
+	if strings.HasPrefix(target, "..") {
+		t.Fatalf("link escapes the data directory: %q", target)
+	}
+}
+
+func TestSymlinkTargetDeleted(t *testing.T) {
+	setFlag(t, "symlink-policy", "preserve")
+	c := newTestFS(t)
+	mustPut(t, c, "/releases/v1.txt", "version 1")
+	putSymlink(c, "/current.txt", "/releases/v1.txt")
+	putSymlink(c, "/latest.txt", "/current.txt")
+
+	do(c, "DELETE", "/releases/v1.txt", "")
+	for _, p := range []string{"/current.txt", "/latest.txt"} {
+		if rec := do(c, "GET", p, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s after deleting the target: %d %q", p, rec.Code, rec.Body)
+		}
+	}
+
+	// Writing the target again revives the link.
+	mustPut(t, c, "/releases/v1.txt", "version 1 again")
+	if rec := do(c, "GET", "/latest.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "version 1 again" {
+		t.Fatalf("GET after rewriting the target: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestSymlinkPutChecks(t *testing.T) {
+	setFlag(t, "symlink-policy", "preserve")
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "a")
+	mustPut(t, c, "/b.txt", "b")
+
+	r := newRequest("PUT", "/a.txt", "")
+	r.Header.Set(symlinkTargetHeader, "/b.txt")
+	r.Header.Set("If-Match", `W/"stale"`)
+	if code := serve(c, r).Code; code != http.StatusPreconditionFailed {
+		t.Errorf("symlink with a stale If-Match: %d", code)
+	}
+	if code := putSymlink(c, "/dir/", "/b.txt"); code != http.StatusBadRequest {
+		t.Errorf("symlink at a directory path: %d", code)
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Body.String() != "a" {
+		t.Fatalf("file replaced: %q", rec.Body)
+	}
+
+	// The file may live in another shard.
+	sc := newShardedFS(t, 4)
+	mustPut(t, sc, "/a.txt", "a")
+	if code := putSymlink(sc, "/a.txt/link", "/a.txt"); code != http.StatusConflict {
+		t.Errorf("symlink below a file: %d", code)
+	}
+}
+
+func TestSafeStatFollow(t *testing.T) {
+	dir := t.TempDir()
+	link := func(name, target string) {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	link("loop-a", "loop-b")
+	link("loop-b", "loop-a")
+	link("c1", "file")
+	for i := 2; i <= 10; i++ {
+		link(fmt.Sprintf("c%d", i), fmt.Sprintf("c%d", i-1))
+	}
+
+	if _, err := safeStatFollow(filepath.Join(dir, "loop-a"), 8); err != errSymlinkDepth {
+		t.Errorf("loop: %v", err)
+	}
+	if _, err := safeStatFollow(filepath.Join(dir, "c10"), 8); err != errSymlinkDepth {
+		t.Errorf("chain longer than the limit: %v", err)
+	}
+	fi, err := safeStatFollow(filepath.Join(dir, "c8"), 8)
+	if err != nil || fi.Size() != 4 {
+		t.Errorf("chain within the limit: %v, %v", fi, err)
+	}
+	if _, err := safeStatFollow(filepath.Join(dir, "missing"), 8); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestSymlinkDepth(t *testing.T) {
+	setFlag(t, "symlink-policy", "preserve")
+	setFlag(t, "symlink-max-depth", "2")
+	c := newTestFS(t)
+	mustPut(t, c, "/a.txt", "a")
+	putSymlink(c, "/l1", "/a.txt")
+	putSymlink(c, "/l2", "/l1")
+	putSymlink(c, "/l3", "/l2")
+	if rec := do(c, "GET", "/l2", ""); rec.Code != http.StatusOK || rec.Body.String() != "a" {
+		t.Fatalf("GET within the depth limit: %d %q", rec.Code, rec.Body)
+	}
+	rec := do(c, "GET", "/l3", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "symlink depth limit exceeded") {
+		t.Fatalf("GET beyond the depth limit: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestSymlinkLoopFollow(t *testing.T) {
+	c := newTestFS(t)
+	os.Symlink("b", filepath.Join(c.dir, "a"))
+	os.Symlink("a", filepath.Join(c.dir, "b"))
+	rec := do(c, "GET", "/a", "")
+	if rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), c.dir) {
+		t.Fatalf("GET loop: %d %q", rec.Code, rec.Body)
+	}
+}
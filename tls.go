@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+var (
+	tlsCert     = flag.String("tls-cert", "", "Path to TLS certificate file")
+	tlsKey      = flag.String("tls-key", "", "Path to TLS private key file")
+	tlsClientCA = flag.String("tls-client-ca", "", "Path to CA certificates for verifying client certificates")
+
+	tlsMinVersionFlag   = flag.String("tls-min-version", "1.2", "Minimum TLS version (1.2 or 1.3)")
+	tlsCipherSuitesFlag = flag.String("tls-cipher-suites", "", "Comma-separated TLS 1.2 cipher suite names; empty uses Go's defaults")
+)
+
+func init() {
+	registerValidator(func() error {
+		if (*tlsCert == "") != (*tlsKey == "") {
+			return errors.New("-tls-cert and -tls-key must be specified together")
+		}
+		if *tlsClientCA != "" && *tlsCert == "" {
+			return errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil
+	})
+	registerValidator(func() error {
+		_, _, err := tlsVersionFlags()
+		return err
+	})
+}
+
+// tlsVersionFlags parses -tls-min-version and -tls-cipher-suites.
+func tlsVersionFlags() (uint16, []uint16, error) {
+	var minVersion uint16
+	switch *tlsMinVersionFlag {
+	case "1.2":
+		minVersion = tls.VersionTLS12
+	case "1.3":
+		minVersion = tls.VersionTLS13
+	default:
+		return 0, nil, fmt.Errorf("-tls-min-version must be 1.2 or 1.3: %q", *tlsMinVersionFlag)
+	}
+	if *tlsCipherSuitesFlag == "" {
+		return minVersion, nil, nil
+	}
+	if minVersion == tls.VersionTLS13 {
+		return 0, nil, errors.New("-tls-cipher-suites has no effect with -tls-min-version 1.3")
+	}
+	var suites []uint16
+	for _, name := range strings.Split(*tlsCipherSuitesFlag, ",") {
+		name = strings.TrimSpace(name)
+		cs := cipherSuiteByName(name)
+		if cs == nil {
+			return 0, nil, fmt.Errorf("unsupported TLS cipher suite %q", name)
+		}
+		if len(cs.SupportedVersions) == 1 && cs.SupportedVersions[0] == tls.VersionTLS13 {
+			// Go ignores CipherSuites for TLS 1.3 and always enables these.
+			return 0, nil, fmt.Errorf("TLS 1.3 cipher suite %q cannot be configured", name)
+		}
+		suites = append(suites, cs.ID)
+	}
+	return minVersion, suites, nil
+}
+
+// cipherSuiteByName looks up a cipher suite Go considers secure.
+func cipherSuiteByName(name string) *tls.CipherSuite {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs
+		}
+	}
+	return nil
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	minVersion, cipherSuites, err := tlsVersionFlags()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			logJSON("tls_client_hello", map[string]interface{}{
+				"remote_addr":   hello.Conn.RemoteAddr().String(),
+				"server_name":   hello.ServerName,
+				"versions":      tlsVersionNames(hello.SupportedVersions),
+				"cipher_suites": cipherSuiteNames(hello.CipherSuites),
+			})
+			return &cert, nil
+		},
+	}
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		fields := map[string]interface{}{
+			"result":       "success",
+			"server_name":  cs.ServerName,
+			"version":      tls.VersionName(cs.Version),
+			"cipher_suite": tls.CipherSuiteName(cs.CipherSuite),
+		}
+		if len(cs.PeerCertificates) > 0 {
+			peer := cs.PeerCertificates[0]
+			fields["peer_subject"] = peer.Subject.String()
+			fields["peer_issuer"] = peer.Issuer.String()
+		}
+		if err := verifyClientCert(config.ClientCAs, cs.PeerCertificates); err != nil {
+			// net/http logs and counts the failed handshake, but without
+			// the certificate.
+			fields["result"] = "failure"
+			fields["error"] = err.Error()
+			logJSON("tls_client_cert", fields)
+			return err
+		}
+		logJSON("tls_handshake", fields)
+		tlsHandshakes.WithLabelValues("success").Inc()
+		return nil
+	}
+	if *tlsClientCA != "" {
+		pem, err := ioutil.ReadFile(*tlsClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *tlsClientCA)
+		}
+		config.ClientCAs = pool
+		// The certificate is verified in VerifyConnection, which Go does
+		// not call when its own verification fails.
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+	suites := "default"
+	if len(cipherSuites) > 0 {
+		// Go does not allow configuring TLS 1.3 suites; they are always enabled.
+		suites = strings.Join(cipherSuiteNames(cipherSuites), ",")
+	}
+	log.Printf("TLS minimum version: %s, cipher suites: %s", tls.VersionName(minVersion), suites)
+	return config, nil
+}
+
+// verifyClientCert verifies the client certificate chain against roots the
+// way tls.RequireAndVerifyClientCert would. Without roots any chain is fine.
+func verifyClientCert(roots *x509.CertPool, certs []*x509.Certificate) error {
+	if roots == nil {
+		return nil
+	}
+	if len(certs) == 0 {
+		return errors.New("tls: client didn't provide a certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("tls: failed to verify client certificate: %v", err)
+	}
+	return nil
+}
+
+func tlsVersionNames(versions []uint16) []string {
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = tls.VersionName(v)
+	}
+	return names
+}
+
+func cipherSuiteNames(suites []uint16) []string {
+	names := make([]string, len(suites))
+	for i, id := range suites {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return names
+}
+
+// serverErrorLog receives errors from net/http. Failed TLS handshakes are
+// only reported there, so they are picked out to be counted.
+var serverErrorLog = log.New(serverErrorWriter{}, "", 0)
+
+type serverErrorWriter struct{}
+
+func (serverErrorWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("TLS handshake error")) {
+		tlsHandshakes.WithLabelValues("failure").Inc()
+		logJSON("tls_handshake", map[string]interface{}{
+			"result": "failure",
+			"error":  string(bytes.TrimSpace(p)),
+		})
+		return len(p), nil
+	}
+	log.Print(string(p))
+	return len(p), nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and key for localhost.
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	return
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// captureLog redirects the standard logger for the duration of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	buf := new(syncBuffer)
+	old := log.Writer()
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(old) })
+	return buf
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+func TestTLSHandshakeLog(t *testing.T) {
+	cert, key := writeTestCert(t)
+	setFlag(t, "tls-cert", cert)
+	setFlag(t, "tls-key", key)
+	logs := captureLog(t)
+
+	config, err := newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ErrorLog: serverErrorLog,
+	}
+	go srv.Serve(tls.NewListener(l, config))
+	defer srv.Close()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	waitFor(t, func() bool { return strings.Contains(logs.String(), `"result":"success"`) })
+	out := logs.String()
+	for _, want := range []string{`"op":"tls_client_hello"`, `"server_name":"localhost"`, `"op":"tls_handshake"`, `"version":"TLS 1.3"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %s does not contain %s", out, want)
+		}
+	}
+
+	// The client rejects the self-signed certificate.
+	if _, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: "localhost"}); err == nil {
+		t.Fatal("expected the client to reject the certificate")
+	}
+	waitFor(t, func() bool { return strings.Contains(logs.String(), `"result":"failure"`) })
+	if !strings.Contains(logs.String(), "TLS handshake error") {
+		t.Errorf("failure log does not contain the error: %s", logs.String())
+	}
+}
+
+// serveTestTLS serves TLS on a local port with the configuration built from
+// the TLS flags, which must be valid.
+func serveTestTLS(t *testing.T) string {
+	t.Helper()
+	cert, key := writeTestCert(t)
+	setFlag(t, "tls-cert", cert)
+	setFlag(t, "tls-key", key)
+	captureLog(t)
+	if errs := configErrors(); errs != "" {
+		t.Fatal(errs)
+	}
+	config, err := newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ErrorLog: serverErrorLog,
+	}
+	go srv.Serve(tls.NewListener(l, config))
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func dialTLS(addr string, config *tls.Config) error {
+	config.InsecureSkipVerify = true
+	conn, err := tls.Dial("tcp", addr, config)
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	newTestFS(t)
+	addr := serveTestTLS(t)
+	if err := dialTLS(addr, &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Error("TLS 1.1 client accepted with the default minimum of 1.2")
+	}
+	if err := dialTLS(addr, &tls.Config{MaxVersion: tls.VersionTLS12}); err != nil {
+		t.Errorf("TLS 1.2 client rejected: %v", err)
+	}
+}
+
+func TestTLSMinVersion13(t *testing.T) {
+	newTestFS(t)
+	setFlag(t, "tls-min-version", "1.3")
+	addr := serveTestTLS(t)
+	if err := dialTLS(addr, &tls.Config{MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Error("TLS 1.2 client accepted with a minimum of 1.3")
+	}
+	if err := dialTLS(addr, &tls.Config{MinVersion: tls.VersionTLS13}); err != nil {
+		t.Errorf("TLS 1.3 client rejected: %v", err)
+	}
+}
+
+func TestTLSCipherSuites(t *testing.T) {
+	newTestFS(t)
+	setFlag(t, "tls-cipher-suites", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	addr := serveTestTLS(t)
+	client := func(suite uint16) *tls.Config {
+		return &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{suite}}
+	}
+	if err := dialTLS(addr, client(tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256)); err == nil {
+		t.Error("client without the configured suite accepted")
+	}
+	if err := dialTLS(addr, client(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384)); err != nil {
+		t.Errorf("client with the configured suite rejected: %v", err)
+	}
+}
+
+func TestTLSFlagValidation(t *testing.T) {
+	for _, tt := range []struct {
+		name, value, want string
+	}{
+		{"tls-min-version", "1.1", "-tls-min-version"},
+		{"tls-cipher-suites", "TLS_NOT_A_SUITE", "unsupported TLS cipher suite"},
+		{"tls-cipher-suites", "TLS_RSA_WITH_RC4_128_SHA", "unsupported TLS cipher suite"},
+		{"tls-cipher-suites", "TLS_AES_256_GCM_SHA384", "TLS 1.3 cipher suite"},
+		{"tls-cipher-suites", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_CHACHA20_POLY1305_SHA256", "TLS 1.3 cipher suite"},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			newTestFS(t)
+			setFlag(t, tt.name, tt.value)
+			if errs := configErrors(); !strings.Contains(errs, tt.want) {
+				t.Fatalf("want error containing %q, got:\n%s", tt.want, errs)
+			}
+		})
+	}
+
+	newTestFS(t)
+	setFlag(t, "tls-min-version", "1.3")
+	setFlag(t, "tls-cipher-suites", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	if errs := configErrors(); !strings.Contains(errs, "no effect with -tls-min-version 1.3") {
+		t.Fatalf("cipher suites with TLS 1.3 only: %s", errs)
+	}
+}
+
+// newTLSConfig parses the TLS flags itself, so it does not depend on the
+// validators having run.
+func TestNewTLSConfigFlags(t *testing.T) {
+	cert, key := writeTestCert(t)
+	setFlag(t, "tls-cert", cert)
+	setFlag(t, "tls-key", key)
+	setFlag(t, "tls-cipher-suites", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+	captureLog(t)
+
+	config, err := newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || len(config.CipherSuites) != 2 || config.CipherSuites[1] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("min version %x, cipher suites %v", config.MinVersion, cipherSuiteNames(config.CipherSuites))
+	}
+
+	setFlag(t, "tls-cipher-suites", "TLS_AES_256_GCM_SHA384")
+	if _, err := newTLSConfig(); err == nil || !strings.Contains(err.Error(), "TLS 1.3 cipher suite") {
+		t.Fatalf("TLS 1.3 suite: %v", err)
+	}
+}
+
+// newTestCertificate returns a certificate for cn signed by parent, or a
+// self-signed one if parent is nil.
+func newTestCertificate(t *testing.T, cn string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  isCA,
+		BasicConstraintsValid: isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	issuer, signer := tmpl, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSClientCertLog(t *testing.T) {
+	newTestFS(t)
+	ca := newTestCertificate(t, "Test CA", true, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600)
+	setFlag(t, "tls-client-ca", caFile)
+	addr := serveTestTLS(t)
+	logs := captureLog(t)
+
+	alice := newTestCertificate(t, "alice", false, &ca)
+	if err := dialTLS(addr, &tls.Config{Certificates: []tls.Certificate{alice}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return strings.Contains(logs.String(), `"result":"success"`) })
+	for _, want := range []string{`"peer_subject":"CN=alice"`, `"peer_issuer":"CN=Test CA"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("success log %s does not contain %s", logs, want)
+		}
+	}
+
+	// Sent although the server does not accept its issuer.
+	mallory := newTestCertificate(t, "mallory", false, nil)
+	err := dialTLS(addr, &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &mallory, nil },
+		MaxVersion:           tls.VersionTLS12,
+	})
+	if err == nil {
+		t.Fatal("untrusted client certificate accepted")
+	}
+	waitFor(t, func() bool { return strings.Contains(logs.String(), `"op":"tls_client_cert"`) })
+	for _, want := range []string{`"result":"failure"`, `"peer_subject":"CN=mallory"`, `"peer_issuer":"CN=mallory"`, "unknown authority"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("failure log %s does not contain %s", logs, want)
+		}
+	}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "CN=mallory") && strings.Contains(line, `"result":"success"`) {
+			t.Errorf("rejected handshake logged as success: %s", line)
+		}
+	}
+}
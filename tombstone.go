@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"os"
+	"path"
+	"strings"
+)
+
+var tombstoneHMACSecret = flag.String("tombstone-hmac-secret", "", "Name tombstones by an HMAC of the file name so deletions cannot be discovered without the secret")
+
+// tombstoneMACLen is the number of hex digits of the HMAC kept in a
+// tombstone name.
+const tombstoneMACLen = 32
+
+// tombstonePath returns the tombstone marking fullpath as deleted.
+func tombstonePath(fullpath string) string {
+	if *tombstoneHMACSecret == "" {
+		return fullpath + tombstone
+	}
+	return fullpath + "." + tombstoneMAC(path.Base(fullpath))
+}
+
+func tombstoneMAC(name string) string {
+	mac := hmac.New(sha256.New, []byte(*tombstoneHMACSecret))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))[:tombstoneMACLen]
+}
+
+// tombstoneTarget returns the data file shadowed by name if name is a
+// tombstone. Tombstones with the plain suffix are recognized even with a
+// secret so that files deleted before it was configured stay deleted.
+func tombstoneTarget(name string) (string, bool) {
+	if strings.HasSuffix(name, tombstone) {
+		return name[:len(name)-len(tombstone)], true
+	}
+	if *tombstoneHMACSecret == "" {
+		return "", false
+	}
+	i := strings.LastIndex(name, ".")
+	if i < 0 || len(name)-i-1 != tombstoneMACLen {
+		return "", false
+	}
+	target := name[:i]
+	if !hmac.Equal([]byte(name[i+1:]), []byte(tombstoneMAC(path.Base(target)))) {
+		return "", false
+	}
+	return target, true
+}
+
+func isTombstone(name string) bool {
+	_, ok := tombstoneTarget(name)
+	return ok
+}
+
+// findTombstone returns the path and stat of the tombstone of fullpath.
+func findTombstone(fullpath string) (string, os.FileInfo, error) {
+	name := tombstonePath(fullpath)
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) && *tombstoneHMACSecret != "" {
+		name = fullpath + tombstone
+		fi, err = os.Stat(name)
+	}
+	return name, fi, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestTombstoneHMAC(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "tombstone-hmac-secret", "s3cret")
+	mustPut(t, c, "/dir/a.txt", "aaa")
+	mustPut(t, c, "/dir/b.txt", "bbb")
+	if rec := do(c, "DELETE", "/dir/a.txt", ""); rec.Code >= 300 {
+		t.Fatalf("DELETE: %d", rec.Code)
+	}
+
+	// Nothing in the directory reveals which file was deleted.
+	var tombstones []string
+	for _, name := range dirNames(t, filepath.Join(c.dir, "dir")) {
+		if strings.HasSuffix(name, tombstone) {
+			t.Fatalf("plain tombstone %s written", name)
+		}
+		if isTombstone(name) {
+			tombstones = append(tombstones, name)
+		}
+	}
+	if len(tombstones) != 1 || !strings.HasPrefix(tombstones[0], "a.txt.") {
+		t.Fatalf("tombstones = %v", tombstones)
+	}
+	if rec := do(c, "GET", "/dir/a.txt", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted file: %d", rec.Code)
+	}
+	if list := do(c, "GET", "/dir/", "").Body.String(); list != "b.txt\n" {
+		t.Fatalf("listing = %q", list)
+	}
+
+	// Without the secret the tombstone looks like any other file.
+	setFlag(t, "tombstone-hmac-secret", "other")
+	if isTombstone(tombstones[0]) {
+		t.Fatal("tombstone recognized with the wrong secret")
+	}
+	setFlag(t, "tombstone-hmac-secret", "")
+	if isTombstone(tombstones[0]) {
+		t.Fatal("tombstone recognized without a secret")
+	}
+}
+
+func TestTombstoneHMACNotForged(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "tombstone-hmac-secret", "s3cret")
+	mustPut(t, c, "/a.txt", "aaa")
+	// A name of the right shape with a wrong MAC does not delete the file.
+	forged := filepath.Join(c.dir, "a.txt."+strings.Repeat("0", tombstoneMACLen))
+	if err := ioutil.WriteFile(forged, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(c, "GET", "/a.txt", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET: %d", rec.Code)
+	}
+}
+
+func TestTombstoneHMACLegacy(t *testing.T) {
+	c := newTestFS(t)
+	mustPut(t, c, "/old.txt", "old")
+	do(c, "DELETE", "/old.txt", "")
+
+	// Files deleted before the secret was set stay deleted.
+	setFlag(t, "tombstone-hmac-secret", "s3cret")
+	if rec := do(c, "GET", "/old.txt", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET: %d", rec.Code)
+	}
+	if res := fileGC(t, c, "/old.txt"); !res.Removed {
+		t.Fatalf("result = %+v", res)
+	}
+}
+
+func TestTombstoneHMACGC(t *testing.T) {
+	c := newTestFS(t)
+	setFlag(t, "tombstone-hmac-secret", "s3cret")
+	mustPut(t, c, "/dir/a.txt", "aaa")
+	mustPut(t, c, "/dir/kept.txt", "kept")
+	do(c, "DELETE", "/dir/a.txt", "")
+
+	g := &gc{dir: c.dir, roots: c.shardDirs()}
+	n, err := g.collectAll(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("found %d tombstones, want 1", n)
+	}
+	names := dirNames(t, filepath.Join(c.dir, "dir"))
+	for _, name := range names {
+		if strings.HasPrefix(name, "a.txt") {
+			t.Errorf("%s left after GC", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "dir/kept.txt")); err != nil {
+		t.Errorf("kept.txt: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	transformRulesFile = flag.String("transform-rules-file", "", "JSON file of rules to transform files on GET")
+	transformTimeout   = flag.Duration("transform-timeout", 30*time.Second, "Maximum run time of a transform command")
+)
+
+type transformRule struct {
+	Pattern     string   `json:"pattern"`
+	ContentType string   `json:"content_type"`
+	Command     []string `json:"command"`
+}
+
+var transformRules []transformRule
+
+func init() {
+	registerValidator(loadTransformRules)
+}
+
+func loadTransformRules() error {
+	if *transformRulesFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(*transformRulesFile)
+	if err != nil {
+		return err
+	}
+	var rules []transformRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("invalid -transform-rules-file: %v", err)
+	}
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid transform pattern %q: %v", rule.Pattern, err)
+		}
+		if rule.ContentType == "" || len(rule.Command) == 0 {
+			return fmt.Errorf("transform rule %q needs content_type and command", rule.Pattern)
+		}
+	}
+	transformRules = rules
+	return nil
+}
+
+// findTransform returns the first rule matching the file name whose output
+// type is acceptable to the client.
+func findTransform(r *http.Request, fullpath string) *transformRule {
+	name := path.Base(fullpath)
+	for i := range transformRules {
+		rule := &transformRules[i]
+		if ok, _ := path.Match(rule.Pattern, name); ok && acceptsType(r, rule.ContentType) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func acceptsType(r *http.Request, ctype string) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	ctype = strings.ToLower(ctype)
+	if i := strings.Index(ctype, ";"); i >= 0 {
+		ctype = strings.TrimSpace(ctype[:i])
+	}
+	major := strings.SplitN(ctype, "/", 2)[0]
+	for _, item := range strings.Split(accept, ",") {
+		mtype, q := parseQuality(item)
+		if q > 0 && (mtype == ctype || mtype == major+"/*") {
+			return true
+		}
+	}
+	return false
+}
+
+func serveTransformed(w http.ResponseWriter, r *http.Request, fullpath string, rule *transformRule) {
+	f, err := os.Open(fullpath)
+	if err != nil {
+		code, msg := classifyFSError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), *transformTimeout)
+	defer cancel()
+	start := time.Now()
+	out := &transformWriter{w: w, ctype: rule.ContentType}
+	cmd := exec.CommandContext(ctx, rule.Command[0], rule.Command[1:]...)
+	cmd.Stdin = f
+	cmd.Stdout = out
+	err = cmd.Run()
+	transformDuration.WithLabelValues(rule.Pattern).Observe(time.Since(start).Seconds())
+	if err == nil {
+		if !out.started {
+			out.start()
+		}
+		return
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", *transformTimeout)
+	}
+	log.Printf("Transform of %s with %s failed: %v", fullpath, rule.Command[0], err)
+	if !out.started {
+		http.Error(w, "Transform failed", http.StatusBadGateway)
+	}
+}
+
+// transformWriter sends the response header on the first write and flushes
+// every chunk so that command output is streamed to the client.
+type transformWriter struct {
+	w       http.ResponseWriter
+	ctype   string
+	started bool
+}
+
+func (t *transformWriter) start() {
+	t.started = true
+	t.w.Header().Set("Content-Type", t.ctype)
+	t.w.Header().Add("Vary", "Accept")
+	t.w.WriteHeader(http.StatusOK)
+}
+
+func (t *transformWriter) Write(p []byte) (int, error) {
+	if !t.started {
+		t.start()
+	}
+	n, err := t.w.Write(p)
+	if fl, ok := t.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
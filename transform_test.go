@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setTransformRules(t *testing.T, rules string) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "rules.json")
+	if err := ioutil.WriteFile(name, []byte(rules), 0666); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "transform-rules-file", name)
+	t.Cleanup(func() { transformRules = nil })
+	if err := loadTransformRules(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getAccept(h http.Handler, p, accept string) (int, string, string) {
+	r := newRequest("GET", p, "")
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	rec := serve(h, r)
+	return rec.Code, rec.Header().Get("Content-Type"), rec.Body.String()
+}
+
+func TestTransform(t *testing.T) {
+	for _, cmd := range []string{"tr", "cat", "false", "sleep"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("%s not available", cmd)
+		}
+	}
+	setTransformRules(t, `[
+		{"pattern": "*.md", "content_type": "text/html", "command": ["tr", "a-z", "A-Z"]},
+		{"pattern": "*.txt", "content_type": "application/x-copy", "command": ["cat"]},
+		{"pattern": "*.fail", "content_type": "text/plain", "command": ["false"]},
+		{"pattern": "*.slow", "content_type": "text/plain", "command": ["sleep", "5"]}
+	]`)
+	c := newTestFS(t)
+	mustPut(t, c, "/doc.md", "# hello")
+	mustPut(t, c, "/a.txt", "plain")
+
+	code, ctype, body := getAccept(c, "/doc.md", "text/html,*/*;q=0.8")
+	if code != http.StatusOK || ctype != "text/html" || body != "# HELLO" {
+		t.Fatalf("transformed: %d %q %q", code, ctype, body)
+	}
+	for _, accept := range []string{"", "application/json", "text/html;q=0"} {
+		if _, _, body := getAccept(c, "/doc.md", accept); body != "# hello" {
+			t.Errorf("Accept %q: transformed to %q", accept, body)
+		}
+	}
+	if _, ctype, body := getAccept(c, "/a.txt", "application/*"); ctype != "application/x-copy" || body != "plain" {
+		t.Errorf("wildcard Accept: %q %q", ctype, body)
+	}
+
+	captureLog(t)
+	mustPut(t, c, "/x.fail", "x")
+	if code, _, _ := getAccept(c, "/x.fail", "text/plain"); code != http.StatusBadGateway {
+		t.Errorf("failing command: %d", code)
+	}
+	setFlag(t, "transform-timeout", "50ms")
+	mustPut(t, c, "/x.slow", "x")
+	if code, _, _ := getAccept(c, "/x.slow", "text/plain"); code != http.StatusBadGateway {
+		t.Errorf("slow command: %d", code)
+	}
+}
+
+func TestTransformRulesInvalid(t *testing.T) {
+	for _, rules := range []string{
+		`{}`,
+		`[{"pattern": "[", "content_type": "text/html", "command": ["cat"]}]`,
+		`[{"pattern": "*.md", "command": ["cat"]}]`,
+		`[{"pattern": "*.md", "content_type": "text/html"}]`,
+	} {
+		name := filepath.Join(t.TempDir(), "rules.json")
+		ioutil.WriteFile(name, []byte(rules), 0666)
+		setFlag(t, "transform-rules-file", name)
+		if err := loadTransformRules(); err == nil {
+			t.Errorf("%s: accepted", strings.Join(strings.Fields(rules), " "))
+		}
+	}
+}
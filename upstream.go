@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	upstreamURL = flag.String("upstream-url", "", "Fetch missing files from this base URL and cache them")
+	cacheTTL    = flag.Duration("cache-ttl", time.Hour, "How long files fetched from -upstream-url are served before being fetched again")
+)
+
+const (
+	upstreamSuffix    = ".restfs-upstream"
+	cacheStatusHeader = "X-Restfs-Cache-Status"
+
+	// Large files may take long to download, so instead of limiting the
+	// whole request, fetches fail when the upstream stops responding.
+	upstreamHeaderTimeout = 30 * time.Second
+	upstreamIdleTimeout   = 30 * time.Second
+)
+
+var upstreamClient = newUpstreamClient()
+
+// upstreamFetches coalesces concurrent fetches of the same file.
+var upstreamFetches flightGroup
+
+func newUpstreamClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = upstreamHeaderTimeout
+	return &http.Client{Transport: t}
+}
+
+type cacheEntry struct {
+	URL     string    `json:"url"`
+	Fetched time.Time `json:"fetched"`
+}
+
+func init() {
+	sidecarSuffixes = append(sidecarSuffixes, upstreamSuffix)
+	registerValidator(func() error {
+		if *upstreamURL == "" {
+			return nil
+		}
+		u, err := url.Parse(*upstreamURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -upstream-url %q", *upstreamURL)
+		}
+		if *cacheTTL <= 0 {
+			return fmt.Errorf("-cache-ttl must be positive: %s", *cacheTTL)
+		}
+		return nil
+	})
+}
+
+// readThrough fetches fullpath from the upstream when it is missing or its
+// cached copy has expired, and returns the lookup to serve. Files stored by
+// PUT are never fetched again.
+func (c *restfs) readThrough(w http.ResponseWriter, r *http.Request, fullpath string, lk *fileLookup) *fileLookup {
+	if *upstreamURL == "" || (lk.stat != nil && lk.stat.IsDir()) {
+		return lk
+	}
+	var entry cacheEntry
+	cached := lk.stat != nil && readSidecar(fullpath, upstreamSuffix, &entry) == nil
+	if lk.stat != nil && !cached {
+		return lk
+	}
+	if cached && time.Since(entry.Fetched) < *cacheTTL {
+		w.Header().Set(cacheStatusHeader, "HIT")
+		return lk
+	}
+	if lk.stat == nil && len(c.dirpaths(r.URL.Path)) > 0 {
+		return lk
+	}
+	fetched, shared := upstreamFetches.do(fullpath, func() *fileLookup {
+		if err := c.fetchUpstream(r, fullpath); err != nil {
+			log.Printf("Failed to fetch %s from upstream: %v", r.URL.Path, err)
+			return nil
+		}
+		return &fileLookup{stat: stat(fullpath), ctype: detectContentType(fullpath, nil)}
+	})
+	if shared {
+		coalescedRequests.Inc()
+	}
+	if fetched == nil {
+		if cached {
+			w.Header().Set(cacheStatusHeader, "HIT")
+		}
+		return lk
+	}
+	w.Header().Set(cacheStatusHeader, "MISS")
+	return fetched
+}
+
+// fetchUpstream stores the upstream copy of the file requested by r. The
+// fetch is shared with other requests and is not canceled with r.
+func (c *restfs) fetchUpstream(r *http.Request, fullpath string) error {
+	c.writes.Add(1)
+	defer c.writes.Done()
+	unlock := pathLocks.lock(fullpath)
+	defer unlock()
+
+	u := strings.TrimRight(*upstreamURL, "/") + r.URL.EscapedPath()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := upstreamClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body := &idleReader{Reader: resp.Body, timer: time.AfterFunc(upstreamIdleTimeout, cancel)}
+	defer body.timer.Stop()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	if !hasDiskSpace(c.dir, resp.ContentLength) {
+		return errors.New("insufficient storage")
+	}
+	n, err := c.saveFile(fullpath, body)
+	if err != nil {
+		return err
+	}
+	bandwidth.reportWrite(c.bandwidthDir(r.URL.Path), n)
+	if err := saveMeta(fullpath, resp.Header); err != nil {
+		return err
+	}
+	changes.publish(fullpath)
+	return writeSidecar(fullpath, upstreamSuffix, &cacheEntry{URL: u, Fetched: time.Now()})
+}
+
+// idleReader fails reads that stall for upstreamIdleTimeout by letting timer
+// cancel the request.
+type idleReader struct {
+	io.Reader
+	timer *time.Timer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.timer.Reset(upstreamIdleTimeout)
+	return r.Reader.Read(p)
+}
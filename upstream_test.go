@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newUpstream(t *testing.T, h http.HandlerFunc) *int32 {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	setFlag(t, "upstream-url", srv.URL)
+	return &hits
+}
+
+func TestUpstreamReadThrough(t *testing.T) {
+	c := newTestFS(t)
+	hits := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "upstream")
+	})
+
+	for i, want := range []string{"MISS", "HIT"} {
+		rec := do(c, "GET", "/a.txt", "")
+		if rec.Body.String() != "upstream" || rec.Header().Get(cacheStatusHeader) != want {
+			t.Fatalf("GET %d = %q, %s", i, rec.Body, rec.Header().Get(cacheStatusHeader))
+		}
+	}
+	if *hits != 1 {
+		t.Fatalf("upstream hits = %d", *hits)
+	}
+
+	setFlag(t, "cache-ttl", "1ns")
+	if rec := do(c, "GET", "/a.txt", ""); rec.Header().Get(cacheStatusHeader) != "MISS" {
+		t.Fatal("expired entry was not fetched again")
+	}
+	if rec := do(c, "GET", "/missing", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET missing = %d", rec.Code)
+	}
+
+	// Files stored by PUT are not fetched.
+	mustPut(t, c, "/local.txt", "local")
+	before := atomic.LoadInt32(hits)
+	if rec := do(c, "GET", "/local.txt", ""); rec.Body.String() != "local" || atomic.LoadInt32(hits) != before {
+		t.Fatal("local file was fetched from upstream")
+	}
+}
+
+func TestUpstreamCoalescesMisses(t *testing.T) {
+	c := newTestFS(t)
+	release := make(chan struct{})
+	hits := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "upstream")
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = do(c, "GET", "/a.txt", "").Body.String()
+		}(i)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(hits) > 0 })
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if *hits != 1 {
+		t.Fatalf("upstream hits = %d, want 1", *hits)
+	}
+	for i, b := range bodies {
+		if b != "upstream" {
+			t.Errorf("GET %d = %q", i, b)
+		}
+	}
+}